@@ -7,12 +7,15 @@ require (
 	github.com/go-chi/chi v4.0.2+incompatible
 	github.com/go-sql-driver/mysql v1.4.1 // indirect
 	github.com/gobuffalo/packr v1.30.1
+	github.com/gorilla/websocket v1.4.1
 	github.com/hashicorp/hcl v1.0.0
 	github.com/imdario/mergo v0.3.8
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/klauspost/compress v1.4.1 // indirect
 	github.com/klauspost/cpuid v1.2.0 // indirect
 	github.com/lib/pq v1.2.0
+	github.com/mattn/go-sqlite3 v1.11.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/umbracle/go-web3 v0.0.0-20191203111801-076498c0fc06
 	google.golang.org/appengine v1.6.5 // indirect
 )
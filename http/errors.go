@@ -0,0 +1,105 @@
+package http
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+)
+
+// Error codes of the api
+const (
+	codeBadRequest   = "BAD_REQUEST"
+	codeUnauthorized = "UNAUTHORIZED"
+	codeForbidden    = "FORBIDDEN"
+	codeNotFound     = "NOT_FOUND"
+	codeUnavailable  = "UNAVAILABLE"
+	codeInternal     = "INTERNAL"
+)
+
+// apiError is an error with the http status and the code returned to the
+// client
+type apiError struct {
+	status int
+	code   string
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+// badRequest returns an error for a malformed request
+func badRequest(err error) error {
+	return &apiError{http.StatusBadRequest, codeBadRequest, err}
+}
+
+// unauthorized returns an error for a request without valid credentials
+func unauthorized(err error) error {
+	return &apiError{http.StatusUnauthorized, codeUnauthorized, err}
+}
+
+// forbidden returns an error for an operation that is not allowed
+func forbidden(err error) error {
+	return &apiError{http.StatusForbidden, codeForbidden, err}
+}
+
+// unavailable returns an error for a service that is not available
+func unavailable(err error) error {
+	return &apiError{http.StatusServiceUnavailable, codeUnavailable, err}
+}
+
+// errorBody is the error returned to the client
+type errorBody struct {
+	Code    string
+	Message string
+}
+
+var (
+	errStoreUnavailable = errors.New("store unavailable")
+	errInternal         = errors.New("internal error")
+
+	errTrackerNotRunning = errors.New("tracker is not running")
+
+	errUnauthorized     = errors.New("missing or invalid admin token")
+	errWebhooksDisabled = errors.New("webhooks are disabled without an admin token")
+)
+
+// toAPIError classifies an error returned by an endpoint. The messages of
+// the store errors are not returned to the client.
+func toAPIError(err error) *apiError {
+	if e, ok := err.(*apiError); ok {
+		return e
+	}
+	switch {
+	case err == store.ErrNotFound:
+		return &apiError{http.StatusNotFound, codeNotFound, err}
+	case err == store.ErrInvalidCursor:
+		return &apiError{http.StatusBadRequest, codeBadRequest, err}
+	case isUnavailable(err):
+		return &apiError{http.StatusServiceUnavailable, codeUnavailable, errStoreUnavailable}
+	default:
+		return &apiError{http.StatusInternalServerError, codeInternal, errInternal}
+	}
+}
+
+// isUnavailable returns true if the error means that the store cannot be
+// reached
+func isUnavailable(err error) bool {
+	if err == driver.ErrBadConn || err == sql.ErrConnDone {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// body returns the error returned to the client
+func (e *apiError) body() *errorBody {
+	return &errorBody{
+		Code:    e.code,
+		Message: e.err.Error(),
+	}
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+// formatDecimal is the format that scales the values by the decimals
+// of the token
+const formatDecimal = "decimal"
+
+// formattedTransfer is a transfer with its value in token units
+type formattedTransfer struct {
+	*store.Transfer
+	FormattedValue string `json:"formatted_value"`
+}
+
+// formattedBalance is a balance with its value in token units
+type formattedBalance struct {
+	*store.Balance
+	FormattedValue string `json:"formatted_value"`
+}
+
+// parseFormat returns true if the values have to be formatted with the
+// decimals of the token
+func parseFormat(r *http.Request) (bool, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+		return false, nil
+	case formatDecimal:
+		return true, nil
+	default:
+		return false, badRequest(fmt.Errorf("unknown format '%s'", format))
+	}
+}
+
+// formatter formats the values of the tokens with their decimals. The
+// decimals are only queried once for each token.
+type formatter struct {
+	store    store.Store
+	decimals map[string]*uint64
+}
+
+func newFormatter(s store.Store) *formatter {
+	return &formatter{
+		store:    s,
+		decimals: map[string]*uint64{},
+	}
+}
+
+// format returns the value in token units or the raw value if the decimals
+// of the token are unknown
+func (f *formatter) format(token, value string) (string, error) {
+	decimals, ok := f.decimals[token]
+	if !ok {
+		metadata, err := f.store.GetToken(web3.HexToAddress(token))
+		if err != nil && err != store.ErrNotFound {
+			return "", err
+		}
+		if metadata != nil {
+			decimals = metadata.Decimals
+		}
+		f.decimals[token] = decimals
+	}
+	if decimals == nil {
+		return value, nil
+	}
+	return formatValue(value, *decimals)
+}
+
+func (f *formatter) transfers(transfers []*store.Transfer) ([]*formattedTransfer, error) {
+	res := []*formattedTransfer{}
+	for _, t := range transfers {
+		value, err := f.format(t.Addr, t.Value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &formattedTransfer{t, value})
+	}
+	return res, nil
+}
+
+func (f *formatter) balances(balances []*store.Balance) ([]*formattedBalance, error) {
+	res := []*formattedBalance{}
+	for _, b := range balances {
+		value, err := f.format(b.Token, b.Value)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &formattedBalance{b, value})
+	}
+	return res, nil
+}
+
+// formatValue scales a base unit value by the decimals of the token
+// (i.e. 1500 with 3 decimals is 1.5)
+func formatValue(value string, decimals uint64) (string, error) {
+	num, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return "", fmt.Errorf("cannot convert value '%s' to big.Int", value)
+	}
+
+	sign := ""
+	if num.Sign() < 0 {
+		sign = "-"
+		num.Abs(num)
+	}
+
+	exp := new(big.Int).Exp(big.NewInt(10), new(big.Int).SetUint64(decimals), nil)
+	integer, frac := new(big.Int).QuoRem(num, exp, new(big.Int))
+	if frac.Sign() == 0 {
+		return sign + integer.String(), nil
+	}
+
+	// pad the fractional part to the number of decimals
+	fracStr := frac.String()
+	fracStr = strings.Repeat("0", int(decimals)-len(fracStr)) + fracStr
+	return sign + integer.String() + "." + strings.TrimRight(fracStr, "0"), nil
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
+	"github.com/umbracle/go-web3"
+)
+
+func TestFormatValue(t *testing.T) {
+	cases := []struct {
+		value    string
+		decimals uint64
+		expected string
+	}{
+		{"0", 18, "0"},
+		{"1500", 3, "1.5"},
+		{"1000", 3, "1"},
+		{"1", 18, "0.000000000000000001"},
+		{"123456789012345678901234567890", 18, "123456789012.34567890123456789"},
+		{"42", 0, "42"},
+		{"-1500", 3, "-1.5"},
+	}
+	for _, c := range cases {
+		res, err := formatValue(c.value, c.decimals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != c.expected {
+			t.Fatalf("expected %s but found %s", c.expected, res)
+		}
+	}
+
+	if _, err := formatValue("0x1", 18); err == nil {
+		t.Fatal("it should fail")
+	}
+}
+
+func TestFormatterUnknownDecimals(t *testing.T) {
+	s := memory.New()
+
+	token := web3.Address{0x1}
+	if _, err := s.WriteReceipt([]*web3.Log{
+		{
+			Address: token,
+			Topics:  []web3.Hash{store.Topics()[0], {}, {}},
+			Data:    append(make([]byte, 31), 0x1),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFormatter(s)
+
+	// the metadata of the token is not resolved yet
+	for _, addr := range []web3.Address{token, {0x2}} {
+		value, err := f.format(addr.String(), "1500")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != "1500" {
+			t.Fatalf("raw value expected but found %s", value)
+		}
+	}
+
+	decimals := uint64(3)
+	if err := s.WriteTokenMetadata(&store.Token{Addr: token.String(), Decimals: &decimals, Resolved: true}); err != nil {
+		t.Fatal(err)
+	}
+	value, err := newFormatter(s).format(token.String(), "1500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "1.5" {
+		t.Fatalf("bad value %s", value)
+	}
+}
@@ -3,40 +3,75 @@ package http
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"math"
+	"math/big"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
 	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/umbracle/go-web3"
 )
 
 // Config is the configuration of the http server
 type Config struct {
 	Addr string `mapstructure:"addr"`
+
+	// MaxLag is the number of blocks the tracker can be behind the head
+	// of the chain and still be ready
+	MaxLag uint64 `mapstructure:"maxlag"`
+
+	// Metrics enables the prometheus metrics at MetricsPath
+	Metrics     bool   `mapstructure:"metrics"`
+	MetricsPath string `mapstructure:"metricspath"`
+
+	// AdminToken is the bearer token required by the webhook endpoints.
+	// The webhooks cannot be managed if it is empty.
+	AdminToken string `mapstructure:"admintoken"`
+
+	// AllowPrivateWebhooks allows webhooks to loopback, private and
+	// link-local addresses
+	AllowPrivateWebhooks bool `mapstructure:"allowprivatewebhooks"`
 }
 
 // DefaultConfig returns the default configuration of the http server
 func DefaultConfig() *Config {
 	return &Config{
-		Addr: "127.0.0.1:5000",
+		Addr:        "127.0.0.1:5000",
+		MaxLag:      10,
+		MetricsPath: "/metrics",
 	}
 }
 
+// Tracker is the interface to query the sync status of the tracker and to
+// subscribe to its transfers
+type Tracker interface {
+	Status() *tracker.Status
+	Subscribe(filter tracker.Filter) *tracker.Subscription
+}
+
 // Server is the http server
 type Server struct {
-	logger *log.Logger
-	srv    *http.Server
-	router chi.Router
-	store  store.Store
+	logger  *log.Logger
+	config  *Config
+	srv     *http.Server
+	router  chi.Router
+	store   store.Store
+	tracker Tracker
 }
 
 // NewServer creates a new http server
-func NewServer(logger *log.Logger, config *Config, store store.Store) (*Server, error) {
+func NewServer(logger *log.Logger, config *Config, store store.Store, tracker Tracker) (*Server, error) {
 	s := &Server{
-		logger: logger,
-		store:  store,
+		logger:  logger,
+		config:  config,
+		store:   store,
+		tracker: tracker,
 	}
 	s.registerEndpoints()
 
@@ -63,10 +98,22 @@ func (s *Server) Stop() {
 
 func (s *Server) registerEndpoints() {
 	s.router = chi.NewRouter()
+	s.router.Use(instrument)
+
+	if s.config.Metrics {
+		s.router.Handle(s.config.MetricsPath, promhttp.Handler())
+	}
+
+	s.router.Get("/health", s.wrap(s.health))
+	s.router.Get("/ready", s.wrap(s.ready))
+	s.router.Get("/status", s.wrap(s.status))
+	s.router.Get("/ws", s.subscribe)
+	s.router.Get("/stream/address/{address}", s.streamAddress)
 
 	s.router.Route("/tokens", func(r chi.Router) {
 		r.Get("/", s.wrap(s.listTokens))
 		r.Get("/{token}", s.wrap(s.listTokenTransfers))
+		r.Get("/{token}/info", s.wrap(s.getToken))
 	})
 	s.router.Route("/from", func(r chi.Router) {
 		r.Get("/{address}", s.wrap(s.listFromTransfers))
@@ -74,83 +121,263 @@ func (s *Server) registerEndpoints() {
 	s.router.Route("/to", func(r chi.Router) {
 		r.Get("/{address}", s.wrap(s.listToTransfers))
 	})
+	s.router.Route("/balances", func(r chi.Router) {
+		r.Get("/{address}", s.wrap(s.listBalances))
+	})
+	s.router.Route("/nfts", func(r chi.Router) {
+		r.Get("/{token}", s.wrap(s.listNFTTransfers))
+		r.Get("/{token}/{id}", s.wrap(s.getNFTOwner))
+	})
+	s.router.Route("/multi", func(r chi.Router) {
+		r.Get("/{token}", s.wrap(s.listMultiTransfers))
+		r.Get("/balances/{address}", s.wrap(s.listMultiBalances))
+	})
+	s.router.Route("/webhooks", func(r chi.Router) {
+		r.Use(s.requireAdmin)
+		r.Post("/", s.wrap(s.createWebhook))
+		r.Get("/", s.wrap(s.listWebhooks))
+		r.Get("/{id}", s.wrap(s.getWebhook))
+		r.Delete("/{id}", s.wrap(s.deleteWebhook))
+		r.Get("/{id}/deliveries", s.wrap(s.listDeliveries))
+	})
 }
 
 type apiResult struct {
-	Status string
-	Result interface{}
+	Status     string
+	Result     interface{}
+	Error      *errorBody `json:",omitempty"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// page is the result of a paginated listing with the cursor of the next page
+type page struct {
+	result interface{}
+	next   string
+}
+
+// nextCursor returns the cursor of the next page or an empty string if the
+// page is not full and there are no more results
+func nextCursor(query store.QueryPagination, num int, cursor func(last int) string) string {
+	if query.Limit <= 0 || num < query.Limit {
+		return ""
+	}
+	return cursor(num - 1)
+}
+
+// logCursor returns the cursor of a transfer. The value is only part of the
+// cursor if the transfers are sorted by value.
+func logCursor(filter store.TransfersFilter, p store.LogPosition, batchIndex uint64, value string) string {
+	c := &store.LogCursor{
+		BlockNumber: p.BlockNumber,
+		LogIndex:    p.LogIndex,
+		BatchIndex:  batchIndex,
+	}
+	if filter.Sort == store.SortValue {
+		c.Value = value
+	}
+	return c.Encode()
 }
 
 func (s *Server) wrap(handler endpoint) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handleErr := func(err error) {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-		}
-
 		resp, err := handler(r)
-
-		status := "SUCCESS"
 		if err != nil {
-			status = "ERROR"
-		}
-		result := apiResult{
-			Status: status,
-			Result: resp,
+			s.writeError(w, r, err)
+			return
 		}
 
-		resultJSON, err := json.Marshal(result)
-		if err != nil {
-			handleErr(err)
-			return
+		var next string
+		if p, ok := resp.(*page); ok {
+			resp, next = p.result, p.next
 		}
+		s.writeResult(w, http.StatusOK, &apiResult{
+			Status:     "SUCCESS",
+			Result:     resp,
+			NextCursor: next,
+		})
+	}
+}
 
-		w.WriteHeader(http.StatusOK)
-		w.Write(resultJSON)
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	if apiErr.err != err {
+		// the error is not returned to the client
+		s.logger.Printf("[ERROR] %s %s: %v", r.Method, r.URL.Path, err)
 	}
+	s.writeResult(w, apiErr.status, &apiResult{
+		Status: "ERROR",
+		Error:  apiErr.body(),
+	})
 }
 
-func parseSingleInt(r *http.Request, name string) (int, bool) {
+func (s *Server) writeResult(w http.ResponseWriter, status int, result *apiResult) {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resultJSON)
+}
+
+// parseAddressParam parses an address in the path of the request
+func parseAddressParam(r *http.Request, name string) (web3.Address, error) {
+	raw := chi.URLParam(r, name)
+
+	var addr web3.Address
+	if err := addr.UnmarshalText([]byte(raw)); err != nil {
+		return addr, badRequest(fmt.Errorf("%s '%s' is not an address", name, raw))
+	}
+	return addr, nil
+}
+
+// parseUint parses a positive integer in the query of the request. It
+// returns false if the value is not set.
+func parseUint(r *http.Request, name string) (uint64, bool, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, false, nil
+	}
+	val, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0, false, badRequest(fmt.Errorf("%s '%s' is not a positive number", name, raw))
+	}
+	return val, true, nil
+}
+
+// parseTime parses a time as a unix timestamp or in RFC3339 format
+func parseTime(raw string) (uint64, error) {
+	if timestamp, err := strconv.ParseUint(raw, 10, 64); err == nil {
+		return timestamp, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return 0, fmt.Errorf("time '%s' is not a unix timestamp or a RFC3339 date", raw)
+	}
+	if t.Unix() < 0 {
+		return 0, fmt.Errorf("time '%s' is before 1970", raw)
+	}
+	return uint64(t.Unix()), nil
+}
+
+// parseRanges parses the block (from_block and to_block) and time (since
+// and until) ranges of a transfers filter
+func parseRanges(r *http.Request, filter *store.TransfersFilter) error {
 	vals := r.URL.Query()
 
-	raw, ok := vals[name]
-	if !ok {
-		return 0, false
+	var err error
+	if filter.FromBlock, _, err = parseUint(r, "from_block"); err != nil {
+		return err
 	}
-	if len(raw) != 1 {
-		return 0, false
+	if filter.ToBlock, _, err = parseUint(r, "to_block"); err != nil {
+		return err
+	}
+	if raw := vals.Get("since"); raw != "" {
+		if filter.FromTime, err = parseTime(raw); err != nil {
+			return badRequest(err)
+		}
 	}
+	if raw := vals.Get("until"); raw != "" {
+		if filter.ToTime, err = parseTime(raw); err != nil {
+			return badRequest(err)
+		}
+	}
+	return nil
+}
 
-	val, err := strconv.Atoi(raw[0])
+// parseSort parses the sort field (sort) and the direction (order) of a
+// transfers filter
+func parseSort(r *http.Request, filter *store.TransfersFilter) error {
+	vals := r.URL.Query()
+
+	switch raw := vals.Get("sort"); raw {
+	case "":
+	case string(store.SortBlock), string(store.SortValue):
+		filter.Sort = store.SortField(raw)
+	default:
+		return badRequest(fmt.Errorf("sort '%s' is not block or value", raw))
+	}
+	switch raw := vals.Get("order"); raw {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return badRequest(fmt.Errorf("order '%s' is not asc or desc", raw))
+	}
+	return nil
+}
+
+// parseStatus parses the confirmation status (status) of a transfers filter
+func parseStatus(r *http.Request, filter *store.TransfersFilter) error {
+	switch raw := r.URL.Query().Get("status"); raw {
+	case "", "all":
+		filter.Status = store.StatusAll
+	case string(store.StatusConfirmed), string(store.StatusPending):
+		filter.Status = store.TransferStatus(raw)
+	default:
+		return badRequest(fmt.Errorf("status '%s' is not confirmed, pending or all", raw))
+	}
+	return nil
+}
+
+// parsePaginationValue parses a limit or an offset. The values are bounded
+// so that they fit in an int on every platform.
+func parsePaginationValue(r *http.Request, name string) (int, bool, error) {
+	val, ok, err := parseUint(r, name)
 	if err != nil {
-		return 0, false
+		return 0, false, err
+	}
+	if val > math.MaxInt32 {
+		return 0, false, badRequest(fmt.Errorf("%s '%d' is larger than %d", name, val, math.MaxInt32))
 	}
-	return val, true
+	return int(val), ok, nil
 }
 
-func parsePagination(r *http.Request, defaultLimit ...int) store.QueryPagination {
+func parsePagination(r *http.Request, defaultLimit ...int) (store.QueryPagination, error) {
 	res := store.QueryPagination{}
-	var ok bool
-	if res.Limit, ok = parseSingleInt(r, "limit"); !ok {
-		if len(defaultLimit) == 1 {
-			res.Limit = defaultLimit[0]
-		}
+	limit, ok, err := parsePaginationValue(r, "limit")
+	if err != nil {
+		return res, err
 	}
-	res.Offset, _ = parseSingleInt(r, "offset")
-	return res
+	if ok {
+		res.Limit = limit
+	} else if len(defaultLimit) == 1 {
+		res.Limit = defaultLimit[0]
+	}
+	offset, _, err := parsePaginationValue(r, "offset")
+	if err != nil {
+		return res, err
+	}
+	res.Offset = offset
+	res.Cursor = r.URL.Query().Get("cursor")
+	return res, nil
 }
 
 func (s *Server) listTokens(r *http.Request) (interface{}, error) {
-	query := parsePagination(r, 100)
+	query, err := parsePagination(r, 100)
+	if err != nil {
+		return nil, err
+	}
 
 	tokens, err := s.store.ListTokens(query)
 	if err != nil {
 		return nil, err
 	}
-	if len(tokens) == 0 {
-		return []string{}, nil
+	next := nextCursor(query, len(tokens), func(last int) string {
+		return store.EncodeTokenCursor(tokens[last].Addr)
+	})
+	return &page{tokens, next}, nil
+}
+
+func (s *Server) getToken(r *http.Request) (interface{}, error) {
+	token, err := parseAddressParam(r, "token")
+	if err != nil {
+		return nil, err
 	}
-	return tokens, nil
+	return s.store.GetToken(token)
 }
 
 func (s *Server) listAccountTransfers(r *http.Request) (interface{}, error) {
@@ -168,7 +395,7 @@ func parseAddresses(r *http.Request, name string) ([]web3.Address, error) {
 	res := make([]web3.Address, len(raw))
 	for indx, i := range raw {
 		if err := res[indx].UnmarshalText([]byte(i)); err != nil {
-			return nil, err
+			return nil, badRequest(fmt.Errorf("%s '%s' is not an address", name, i))
 		}
 	}
 
@@ -176,10 +403,8 @@ func parseAddresses(r *http.Request, name string) ([]web3.Address, error) {
 }
 
 func (s *Server) listTokenTransfers(r *http.Request) (interface{}, error) {
-	tokenID := chi.URLParam(r, "token")
-
-	var token web3.Address
-	if err := token.UnmarshalText([]byte(tokenID)); err != nil {
+	token, err := parseAddressParam(r, "token")
+	if err != nil {
 		return nil, err
 	}
 	from, err := parseAddresses(r, "from")
@@ -191,7 +416,10 @@ func (s *Server) listTokenTransfers(r *http.Request) (interface{}, error) {
 		return nil, err
 	}
 
-	query := parsePagination(r, 100)
+	query, err := parsePagination(r, 100)
+	if err != nil {
+		return nil, err
+	}
 	filter := store.TransfersFilter{
 		QueryPagination: query,
 		Tokens: []web3.Address{
@@ -200,14 +428,49 @@ func (s *Server) listTokenTransfers(r *http.Request) (interface{}, error) {
 		From: from,
 		To:   to,
 	}
-	return s.store.GetTokenTransfers(filter)
+	if err := parseRanges(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseSort(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseStatus(r, &filter); err != nil {
+		return nil, err
+	}
+	// an unknown token is not found instead of without transfers
+	if _, err := s.store.GetToken(token); err != nil {
+		return nil, err
+	}
+	return s.getTokenTransfers(r, filter)
 }
 
-func (s *Server) listFromTransfers(r *http.Request) (interface{}, error) {
-	address := chi.URLParam(r, "address")
+// getTokenTransfers returns the transfers that match the filter with their
+// values formatted if requested
+func (s *Server) getTokenTransfers(r *http.Request, filter store.TransfersFilter) (interface{}, error) {
+	decimal, err := parseFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := s.store.GetTokenTransfers(filter)
+	if err != nil {
+		return nil, err
+	}
+	next := nextCursor(filter.QueryPagination, len(transfers), func(last int) string {
+		return logCursor(filter, transfers[last].LogPosition, 0, transfers[last].Value)
+	})
+	if !decimal {
+		return &page{transfers, next}, nil
+	}
+	formatted, err := newFormatter(s.store).transfers(transfers)
+	if err != nil {
+		return nil, err
+	}
+	return &page{formatted, next}, nil
+}
 
-	var from web3.Address
-	if err := from.UnmarshalText([]byte(address)); err != nil {
+func (s *Server) listFromTransfers(r *http.Request) (interface{}, error) {
+	from, err := parseAddressParam(r, "address")
+	if err != nil {
 		return nil, err
 	}
 
@@ -220,21 +483,31 @@ func (s *Server) listFromTransfers(r *http.Request) (interface{}, error) {
 		return nil, err
 	}
 
-	query := parsePagination(r, 100)
+	query, err := parsePagination(r, 100)
+	if err != nil {
+		return nil, err
+	}
 	filter := store.TransfersFilter{
 		QueryPagination: query,
 		Tokens:          tokens,
 		From:            []web3.Address{from},
 		To:              to,
 	}
-	return s.store.GetTokenTransfers(filter)
+	if err := parseRanges(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseSort(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseStatus(r, &filter); err != nil {
+		return nil, err
+	}
+	return s.getTokenTransfers(r, filter)
 }
 
 func (s *Server) listToTransfers(r *http.Request) (interface{}, error) {
-	address := chi.URLParam(r, "address")
-
-	var to web3.Address
-	if err := to.UnmarshalText([]byte(address)); err != nil {
+	to, err := parseAddressParam(r, "address")
+	if err != nil {
 		return nil, err
 	}
 
@@ -247,12 +520,185 @@ func (s *Server) listToTransfers(r *http.Request) (interface{}, error) {
 		return nil, err
 	}
 
-	query := parsePagination(r, 100)
+	query, err := parsePagination(r, 100)
+	if err != nil {
+		return nil, err
+	}
 	filter := store.TransfersFilter{
 		QueryPagination: query,
 		Tokens:          tokens,
 		To:              []web3.Address{to},
 		From:            from,
 	}
-	return s.store.GetTokenTransfers(filter)
+	if err := parseRanges(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseSort(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseStatus(r, &filter); err != nil {
+		return nil, err
+	}
+	return s.getTokenTransfers(r, filter)
+}
+
+func (s *Server) listBalances(r *http.Request) (interface{}, error) {
+	account, err := parseAddressParam(r, "address")
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := parseAddresses(r, "tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	decimal, err := parseFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	block, ok, err := parseUint(r, "block")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		balances, err := s.store.GetBalances(account, tokens)
+		if err != nil || !decimal {
+			return balances, err
+		}
+		return newFormatter(s.store).balances(balances)
+	}
+
+	// historical balances
+	if len(tokens) == 0 {
+		return nil, badRequest(fmt.Errorf("tokens are required to query the balances at a block"))
+	}
+	balances := []*store.Balance{}
+	for _, token := range tokens {
+		balance, err := s.store.GetBalanceAt(token, account, block)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	if decimal {
+		return newFormatter(s.store).balances(balances)
+	}
+	return balances, nil
+}
+
+func (s *Server) listNFTTransfers(r *http.Request) (interface{}, error) {
+	token, err := parseAddressParam(r, "token")
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseAddresses(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseAddresses(r, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := parsePagination(r, 100)
+	if err != nil {
+		return nil, err
+	}
+	filter := store.TransfersFilter{
+		QueryPagination: query,
+		Tokens: []web3.Address{
+			token,
+		},
+		From: from,
+		To:   to,
+	}
+	if err := parseRanges(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseSort(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseStatus(r, &filter); err != nil {
+		return nil, err
+	}
+	transfers, err := s.store.GetNFTTransfers(filter)
+	if err != nil {
+		return nil, err
+	}
+	next := nextCursor(query, len(transfers), func(last int) string {
+		return logCursor(filter, transfers[last].LogPosition, 0, transfers[last].TokenID)
+	})
+	return &page{transfers, next}, nil
+}
+
+func (s *Server) getNFTOwner(r *http.Request) (interface{}, error) {
+	token, err := parseAddressParam(r, "token")
+	if err != nil {
+		return nil, err
+	}
+	id, ok := new(big.Int).SetString(chi.URLParam(r, "id"), 10)
+	if !ok {
+		return nil, badRequest(fmt.Errorf("token id '%s' is not a number", chi.URLParam(r, "id")))
+	}
+	return s.store.GetNFTOwner(token, id)
+}
+
+func (s *Server) listMultiTransfers(r *http.Request) (interface{}, error) {
+	token, err := parseAddressParam(r, "token")
+	if err != nil {
+		return nil, err
+	}
+	from, err := parseAddresses(r, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseAddresses(r, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := parsePagination(r, 100)
+	if err != nil {
+		return nil, err
+	}
+	filter := store.TransfersFilter{
+		QueryPagination: query,
+		Tokens: []web3.Address{
+			token,
+		},
+		From: from,
+		To:   to,
+	}
+	if err := parseRanges(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseSort(r, &filter); err != nil {
+		return nil, err
+	}
+	if err := parseStatus(r, &filter); err != nil {
+		return nil, err
+	}
+	transfers, err := s.store.GetMultiTransfers(filter)
+	if err != nil {
+		return nil, err
+	}
+	next := nextCursor(query, len(transfers), func(last int) string {
+		return logCursor(filter, transfers[last].LogPosition, transfers[last].BatchIndex, transfers[last].Value)
+	})
+	return &page{transfers, next}, nil
+}
+
+func (s *Server) listMultiBalances(r *http.Request) (interface{}, error) {
+	account, err := parseAddressParam(r, "address")
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := parseAddresses(r, "tokens")
+	if err != nil {
+		return nil, err
+	}
+	return s.store.GetMultiBalances(account, tokens)
 }
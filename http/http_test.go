@@ -0,0 +1,269 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+type mockStore struct {
+	store.Store
+	transfers []*store.Transfer
+	err       error
+}
+
+func (m *mockStore) Ping() error {
+	return m.err
+}
+
+func (m *mockStore) GetTokenTransfers(filter store.TransfersFilter) ([]*store.Transfer, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	cursor, err := store.DecodeTransfersCursor(filter)
+	if err != nil {
+		return nil, err
+	}
+	contains := func(addrs []web3.Address, addr string) bool {
+		for _, a := range addrs {
+			if a.String() == addr {
+				return true
+			}
+		}
+		return len(addrs) == 0
+	}
+	// the transfers are in chain order
+	transfers := []*store.Transfer{}
+	for _, t := range m.transfers {
+		if cursor != nil && (t.BlockNumber < cursor.BlockNumber || t.BlockNumber == cursor.BlockNumber && t.LogIndex <= cursor.LogIndex) {
+			continue
+		}
+		if contains(filter.From, t.From) && contains(filter.To, t.To) {
+			transfers = append(transfers, t)
+		}
+	}
+	if filter.Limit < len(transfers) {
+		return transfers[:filter.Limit], nil
+	}
+	return transfers, nil
+}
+
+// knownToken is the only token of the mock store
+var knownToken = web3.HexToAddress("0x0000000000000000000000000000000000000001")
+
+func (m *mockStore) GetToken(token web3.Address) (*store.Token, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if token != knownToken {
+		return nil, store.ErrNotFound
+	}
+	return &store.Token{Addr: token.String()}, nil
+}
+
+func newTestServer(s store.Store) *Server {
+	srv := &Server{
+		logger: log.New(ioutil.Discard, "", 0),
+		config: DefaultConfig(),
+		store:  s,
+	}
+	srv.registerEndpoints()
+	return srv
+}
+
+// get returns the status and the result of a request to the server
+func (s *Server) get(t *testing.T, url string) (int, *apiResult) {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+
+	var result apiResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, &result
+}
+
+func TestParseRanges(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected store.TransfersFilter
+		err      bool
+	}{
+		{"", store.TransfersFilter{}, false},
+		{"from_block=10&to_block=20", store.TransfersFilter{FromBlock: 10, ToBlock: 20}, false},
+		{"since=1000&until=2000", store.TransfersFilter{FromTime: 1000, ToTime: 2000}, false},
+		{"since=2020-01-01T00:00:00Z", store.TransfersFilter{FromTime: 1577836800}, false},
+		{"from_block=-1", store.TransfersFilter{}, true},
+		{"until=yesterday", store.TransfersFilter{}, true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/tokens?"+c.query, nil)
+
+		filter := store.TransfersFilter{}
+		err := parseRanges(r, &filter)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%s should fail", c.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filter.FromBlock != c.expected.FromBlock || filter.ToBlock != c.expected.ToBlock || filter.FromTime != c.expected.FromTime || filter.ToTime != c.expected.ToTime {
+			t.Fatalf("bad ranges for %s", c.query)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	cases := []struct {
+		query string
+		sort  store.SortField
+		desc  bool
+		err   bool
+	}{
+		{"", "", false, false},
+		{"sort=value", store.SortValue, false, false},
+		{"sort=block&order=desc", store.SortBlock, true, false},
+		{"order=asc", "", false, false},
+		{"sort=time", "", false, true},
+		{"order=up", "", false, true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/tokens?"+c.query, nil)
+
+		filter := store.TransfersFilter{}
+		err := parseSort(r, &filter)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%s should fail", c.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filter.Sort != c.sort || filter.Desc != c.desc {
+			t.Fatalf("bad sort for %s", c.query)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	cases := []struct {
+		query  string
+		status store.TransferStatus
+		err    bool
+	}{
+		{"", store.StatusAll, false},
+		{"status=all", store.StatusAll, false},
+		{"status=confirmed", store.StatusConfirmed, false},
+		{"status=pending", store.StatusPending, false},
+		{"status=final", "", true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/tokens?"+c.query, nil)
+
+		filter := store.TransfersFilter{}
+		err := parseStatus(r, &filter)
+		if c.err {
+			if err == nil {
+				t.Fatalf("%s should fail", c.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filter.Status != c.status {
+			t.Fatalf("bad status for %s", c.query)
+		}
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	m := &mockStore{}
+	for i := uint64(1); i <= 3; i++ {
+		m.transfers = append(m.transfers, &store.Transfer{
+			LogPosition: store.LogPosition{BlockNumber: i, LogIndex: 1},
+		})
+	}
+	s := newTestServer(m)
+
+	// the page is full
+	_, result := s.get(t, "/tokens/0x0000000000000000000000000000000000000001?limit=2")
+	cursor, err := store.DecodeLogCursor(result.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.BlockNumber != 2 || cursor.LogIndex != 1 {
+		t.Fatal("bad cursor")
+	}
+	// there are no more results
+	if _, result := s.get(t, "/tokens/0x0000000000000000000000000000000000000001?limit=5"); result.NextCursor != "" {
+		t.Fatal("expected no cursor")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	token := "/tokens/0x0000000000000000000000000000000000000001"
+	unknown := "/tokens/0x0000000000000000000000000000000000000002"
+
+	cases := []struct {
+		url    string
+		err    error
+		status int
+		code   string
+	}{
+		{token, nil, http.StatusOK, ""},
+		{"/tokens/0x1234", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "?limit=abc", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "?offset=-1", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "?offset=18446744073709551615", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "?limit=2147483648", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "?limit=2147483647", nil, http.StatusOK, ""},
+		{token + "?from=0x1234", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "?cursor=bad", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "?sort=time", nil, http.StatusBadRequest, codeBadRequest},
+		{token + "/info", nil, http.StatusOK, ""},
+		{unknown, nil, http.StatusNotFound, codeNotFound},
+		{unknown + "/info", nil, http.StatusNotFound, codeNotFound},
+		{token, &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, http.StatusServiceUnavailable, codeUnavailable},
+		{token, fmt.Errorf("syntax error"), http.StatusInternalServerError, codeInternal},
+	}
+	for _, c := range cases {
+		s := newTestServer(&mockStore{err: c.err})
+
+		status, result := s.get(t, c.url)
+		if status != c.status {
+			t.Fatalf("%s: expected status %d but found %d", c.url, c.status, status)
+		}
+		if c.code == "" {
+			if result.Status != "SUCCESS" || result.Error != nil {
+				t.Fatalf("%s: expected success", c.url)
+			}
+			continue
+		}
+		if result.Status != "ERROR" || result.Error == nil || result.Error.Code != c.code {
+			t.Fatalf("%s: expected error %s", c.url, c.code)
+		}
+		if result.Error.Message == "" {
+			t.Fatalf("%s: expected an error message", c.url)
+		}
+	}
+
+	// the messages of the internal errors are not returned
+	s := newTestServer(&mockStore{err: fmt.Errorf("syntax error")})
+	if _, result := s.get(t, token); result.Error.Message != "internal error" {
+		t.Fatal("internal error message returned")
+	}
+}
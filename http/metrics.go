@@ -0,0 +1,53 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tokentracker",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of http requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	metricRequestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tokentracker",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the http requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(metricRequests, metricRequestLatency)
+}
+
+// instrument measures the requests by the chi route pattern so that the
+// paths with parameters share the same metrics
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// the pattern is only known after the request is routed
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unknown"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metricRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		metricRequestLatency.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	s := newTestServer(&mockStore{})
+	s.config.Metrics = true
+	s.registerEndpoints()
+
+	s.get(t, "/tokens/0x0000000000000000000000000000000000000001")
+	s.get(t, "/tokens/0x1234")
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the requests are grouped by route
+	for _, code := range []string{"200", "400"} {
+		metric := `tokentracker_http_requests_total{code="` + code + `",method="GET",route="/tokens/{token}"}`
+		if !strings.Contains(string(body), metric) {
+			t.Fatalf("metric %s not found", metric)
+		}
+	}
+}
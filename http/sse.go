@@ -0,0 +1,245 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
+	"github.com/umbracle/go-web3"
+)
+
+const (
+	// sseHeartbeatInterval is the interval of the comments that keep the
+	// connection open through the proxies
+	sseHeartbeatInterval = 30 * time.Second
+
+	// sseReplayPage is the number of transfers queried at once to replay
+	// the transfers missed by a client
+	sseReplayPage = 100
+)
+
+// sseWriter writes server-sent events
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// event writes an event. The id is omitted if it is empty.
+func (s *sseWriter) event(name, id string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, raw); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// heartbeat writes a comment that is ignored by the clients
+func (s *sseWriter) heartbeat() error {
+	if _, err := fmt.Fprint(s.w, ": heartbeat\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// reorgEvent is the data of a reorg event
+type reorgEvent struct {
+	BlockHash   string
+	BlockNumber uint64
+}
+
+// transferID returns the id of the event of a transfer, its position in
+// the chain as a cursor
+func transferID(t *store.Transfer) *store.LogCursor {
+	return &store.LogCursor{
+		BlockNumber: t.BlockNumber,
+		LogIndex:    t.LogIndex,
+	}
+}
+
+// afterCursor returns true if the transfer comes after the cursor in
+// chain order
+func afterCursor(t *store.Transfer, c *store.LogCursor) bool {
+	if t.BlockNumber != c.BlockNumber {
+		return t.BlockNumber > c.BlockNumber
+	}
+	return t.LogIndex > c.LogIndex
+}
+
+// streamAddress streams as server-sent events the ERC20 transfers from or
+// to an address (transfer events) and the blocks removed by a reorg (reorg
+// events). The id of the transfer events is their position in the chain. A
+// client that reconnects with the Last-Event-ID header gets the transfers
+// after that position from the store first.
+func (s *Server) streamAddress(w http.ResponseWriter, r *http.Request) {
+	address, err := parseAddressParam(r, "address")
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	var last *store.LogCursor
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if last, err = store.DecodeLogCursor(id); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+	}
+	if s.tracker == nil {
+		s.writeError(w, r, unavailable(errTrackerNotRunning))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, r, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	// subscribe before the replay to not miss the transfers written
+	// in between
+	sub := s.tracker.Subscribe(tracker.Filter{Accounts: []web3.Address{address}})
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sse := &sseWriter{w: w, flusher: flusher}
+	if last != nil {
+		if last, err = s.replayTransfers(sse, address, last); err != nil {
+			s.logger.Printf("[ERROR] failed to replay the transfers of %s: %v", address, err)
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evnt, ok := <-sub.EventCh():
+			if !ok {
+				// the client reconnects and replays the transfers
+				// it missed
+				return
+			}
+			if err := s.sendEvent(sse, evnt, &last); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := sse.heartbeat(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sendEvent writes the transfers of an event or a reorg. The transfers that
+// were already replayed from the store are skipped until the stream gets
+// past the last replayed transfer.
+func (s *Server) sendEvent(sse *sseWriter, evnt *tracker.Event, last **store.LogCursor) error {
+	if evnt.Type == tracker.EventRemoved {
+		*last = nil
+
+		data := &reorgEvent{
+			BlockHash:   evnt.BlockHash,
+			BlockNumber: evnt.BlockNumber,
+		}
+		if evnt.BlockNumber == 0 {
+			// there is no position before the genesis, the event has
+			// no id
+			return sse.event("reorg", "", data)
+		}
+		// the id is the position before the block so that a client that
+		// resumes from it gets the transfers of the new block
+		id := &store.LogCursor{
+			BlockNumber: evnt.BlockNumber - 1,
+			LogIndex:    math.MaxUint64,
+		}
+		return sse.event("reorg", id.Encode(), data)
+	}
+	for _, t := range evnt.Transfers {
+		if *last != nil {
+			if !afterCursor(t, *last) {
+				continue
+			}
+			*last = nil
+		}
+		if err := sse.event("transfer", transferID(t).Encode(), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayTransfers writes the transfers from or to the address after the
+// cursor. It returns the position of the last transfer written.
+func (s *Server) replayTransfers(sse *sseWriter, address web3.Address, cursor *store.LogCursor) (*store.LogCursor, error) {
+	for {
+		// the store filters by source and destination separately
+		pages := [][]*store.Transfer{}
+		for _, filter := range []store.TransfersFilter{
+			{From: []web3.Address{address}},
+			{To: []web3.Address{address}},
+		} {
+			filter.Limit = sseReplayPage
+			filter.Cursor = cursor.Encode()
+			transfers, err := s.store.GetTokenTransfers(filter)
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, transfers)
+		}
+
+		// a full page may have more transfers after it. Only the transfers
+		// before the end of the full pages are complete.
+		var bound *store.LogCursor
+		for _, page := range pages {
+			if len(page) == sseReplayPage {
+				end := transferID(page[len(page)-1])
+				if bound == nil || !afterCursor(page[len(page)-1], bound) {
+					bound = end
+				}
+			}
+		}
+
+		transfers := append(pages[0], pages[1]...)
+		sort.Slice(transfers, func(i, j int) bool {
+			return afterCursor(transfers[j], transferID(transfers[i]))
+		})
+		for indx, t := range transfers {
+			if bound != nil && afterCursor(t, bound) {
+				break
+			}
+			// the transfers from the address to itself are in both pages
+			if indx > 0 && !afterCursor(t, transferID(transfers[indx-1])) {
+				continue
+			}
+			if err := sse.event("transfer", transferID(t).Encode(), t); err != nil {
+				return nil, err
+			}
+			cursor = transferID(t)
+		}
+		if bound == nil {
+			return cursor, nil
+		}
+		cursor = bound
+	}
+}
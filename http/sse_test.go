@@ -0,0 +1,159 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
+)
+
+type sseEvent struct {
+	id, name, data string
+}
+
+// readEvents parses the server-sent events of a stream
+func readEvents(r *bufio.Reader) <-chan *sseEvent {
+	ch := make(chan *sseEvent, 16)
+	go func() {
+		defer close(ch)
+		evnt := &sseEvent{}
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				if evnt.name != "" {
+					ch <- evnt
+				}
+				evnt = &sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				evnt.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				evnt.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				evnt.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return ch
+}
+
+func TestStreamAddress(t *testing.T) {
+	account := "0x0000000000000000000000000000000000000001"
+	other := "0x0000000000000000000000000000000000000002"
+
+	transfer := func(block, index uint64, from, to string) *store.Transfer {
+		return &store.Transfer{
+			LogPosition: store.LogPosition{BlockNumber: block, LogIndex: index},
+			From:        from,
+			To:          to,
+			Value:       "1",
+		}
+	}
+	m := &mockStore{
+		transfers: []*store.Transfer{
+			transfer(1, 0, account, other),
+			transfer(1, 1, other, account),
+			transfer(2, 0, other, other),
+			transfer(2, 3, account, account),
+		},
+	}
+	broker := tracker.NewBroker()
+
+	s := newTestServer(m)
+	s.tracker = &mockTracker{broker: broker}
+
+	srv := httptest.NewServer(s.router)
+	defer srv.Close()
+
+	// resume after the first transfer
+	req, err := http.NewRequest("GET", srv.URL+"/stream/address/"+account, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", transferID(m.transfers[0]).Encode())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("bad content type %s", ct)
+	}
+	events := readEvents(bufio.NewReader(resp.Body))
+
+	expectTransfer := func(block, index uint64) {
+		evnt := <-events
+		if evnt == nil || evnt.name != "transfer" {
+			t.Fatal("transfer event expected")
+		}
+		var transfer store.Transfer
+		if err := json.Unmarshal([]byte(evnt.data), &transfer); err != nil {
+			t.Fatal(err)
+		}
+		if transfer.BlockNumber != block || transfer.LogIndex != index {
+			t.Fatalf("expected transfer %d:%d but found %d:%d", block, index, transfer.BlockNumber, transfer.LogIndex)
+		}
+		if evnt.id != transferID(&transfer).Encode() {
+			t.Fatal("bad event id")
+		}
+	}
+
+	// replay from the store. The transfer to itself is sent once.
+	expectTransfer(1, 1)
+	expectTransfer(2, 3)
+
+	// the live transfers that were replayed are skipped
+	broker.Publish(&tracker.Event{
+		Type: tracker.EventAdded,
+		Transfers: []*store.Transfer{
+			transfer(2, 3, account, account),
+			transfer(3, 0, other, other),
+			transfer(3, 1, other, account),
+		},
+	})
+	expectTransfer(3, 1)
+
+	broker.Publish(&tracker.Event{Type: tracker.EventRemoved, BlockHash: "0x3", BlockNumber: 3})
+	evnt := <-events
+	if evnt == nil || evnt.name != "reorg" {
+		t.Fatal("reorg event expected")
+	}
+	cursor, err := store.DecodeLogCursor(evnt.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the reorg id is between the previous block and the removed one
+	if afterCursor(transfer(2, 3, "", ""), cursor) || !afterCursor(transfer(3, 0, "", ""), cursor) {
+		t.Fatal("the reorg id has to be before the removed block")
+	}
+
+	// the reorg of the genesis has no id
+	broker.Publish(&tracker.Event{Type: tracker.EventRemoved, BlockHash: "0x0", BlockNumber: 0})
+	if evnt = <-events; evnt == nil || evnt.name != "reorg" || evnt.id != "" {
+		t.Fatal("reorg event without id expected")
+	}
+}
+
+func TestStreamAddressBadID(t *testing.T) {
+	s := newTestServer(&mockStore{})
+	s.tracker = &mockTracker{broker: tracker.NewBroker()}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/stream/address/0x0000000000000000000000000000000000000001", nil)
+	req.Header.Set("Last-Event-ID", "bad")
+	s.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request but found %d", w.Code)
+	}
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
+)
+
+// health returns ok while the process is alive
+func (s *Server) health(r *http.Request) (interface{}, error) {
+	return "ok", nil
+}
+
+// ready returns ok if the store can be reached and the tracker is not
+// more than MaxLag blocks behind the head of the chain
+func (s *Server) ready(r *http.Request) (interface{}, error) {
+	if err := s.store.Ping(); err != nil {
+		s.logger.Printf("[ERROR] store is not reachable: %v", err)
+		return nil, unavailable(errStoreUnavailable)
+	}
+	status, err := s.syncStatus()
+	if err != nil {
+		return nil, err
+	}
+	if !status.Synced {
+		return nil, unavailable(fmt.Errorf("tracker is syncing at block %d of %d", status.LastBlock, status.Head))
+	}
+	if status.Lag > s.config.MaxLag {
+		return nil, unavailable(fmt.Errorf("tracker is %d blocks behind the head", status.Lag))
+	}
+	return "ok", nil
+}
+
+// status returns the sync status of the tracker
+func (s *Server) status(r *http.Request) (interface{}, error) {
+	return s.syncStatus()
+}
+
+func (s *Server) syncStatus() (*tracker.Status, error) {
+	if s.tracker == nil {
+		return nil, unavailable(errTrackerNotRunning)
+	}
+	return s.tracker.Status(), nil
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
+)
+
+type mockTracker struct {
+	status *tracker.Status
+	broker *tracker.Broker
+}
+
+func (m *mockTracker) Status() *tracker.Status {
+	return m.status
+}
+
+func (m *mockTracker) Subscribe(filter tracker.Filter) *tracker.Subscription {
+	return m.broker.Subscribe(filter)
+}
+
+func TestReady(t *testing.T) {
+	storeErr := &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}
+
+	cases := []struct {
+		status   *tracker.Status
+		storeErr error
+		code     int
+	}{
+		// caught up
+		{&tracker.Status{LastBlock: 100, Head: 105, Lag: 5, Synced: true}, nil, http.StatusOK},
+		// store not reachable
+		{&tracker.Status{LastBlock: 100, Head: 100, Synced: true}, storeErr, http.StatusServiceUnavailable},
+		// historical sync
+		{&tracker.Status{LastBlock: 50, Head: 100, Lag: 50}, nil, http.StatusServiceUnavailable},
+		// behind the head
+		{&tracker.Status{LastBlock: 50, Head: 100, Lag: 50, Synced: true}, nil, http.StatusServiceUnavailable},
+		// no tracker
+		{nil, nil, http.StatusServiceUnavailable},
+	}
+	for indx, c := range cases {
+		s := newTestServer(&mockStore{err: c.storeErr})
+		if c.status != nil {
+			s.tracker = &mockTracker{status: c.status}
+		}
+
+		// the process is alive in all the cases
+		if status, _ := s.get(t, "/health"); status != http.StatusOK {
+			t.Fatalf("%d: bad health status %d", indx, status)
+		}
+		status, result := s.get(t, "/ready")
+		if status != c.code {
+			t.Fatalf("%d: expected status %d but found %d", indx, c.code, status)
+		}
+		if status != http.StatusOK && (result.Error == nil || result.Error.Code != codeUnavailable) {
+			t.Fatalf("%d: expected unavailable error", indx)
+		}
+	}
+}
+
+func TestReadyStalled(t *testing.T) {
+	s := newTestServer(&mockStore{})
+	tr := &mockTracker{status: &tracker.Status{LastBlock: 100, Head: 100, Synced: true}}
+	s.tracker = tr
+
+	if status, _ := s.get(t, "/ready"); status != http.StatusOK {
+		t.Fatalf("expected ready but found %d", status)
+	}
+
+	// the head advances while the indexing stalls
+	tr.status = &tracker.Status{LastBlock: 100, Head: 120, Lag: 20, Synced: true}
+	if status, _ := s.get(t, "/ready"); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready but found %d", status)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s := newTestServer(&mockStore{})
+	s.tracker = &mockTracker{status: &tracker.Status{LastBlock: 90, Head: 100, Lag: 10, Synced: true}}
+
+	status, result := s.get(t, "/status")
+	if status != http.StatusOK {
+		t.Fatalf("bad status %d", status)
+	}
+	res := result.Result.(map[string]interface{})
+	if res["LastBlock"] != 90.0 || res["Head"] != 100.0 || res["Lag"] != 10.0 || res["Synced"] != true {
+		t.Fatalf("bad sync status: %v", res)
+	}
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
+	"github.com/go-chi/chi"
+	"github.com/umbracle/go-web3"
+)
+
+// maxWebhookBody is the maximum size of the body to create a webhook
+const maxWebhookBody = 1 << 20
+
+// requireAdmin rejects the requests without the admin token as a bearer
+// token in the Authorization header
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" {
+			s.writeError(w, r, forbidden(errWebhooksDisabled))
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			s.writeError(w, r, unauthorized(errUnauthorized))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateWebhookURL checks that the url is an http url. Unless private
+// webhooks are allowed, the host cannot resolve to a loopback, private or
+// link-local address.
+func (s *Server) validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return badRequest(fmt.Errorf("url '%s' is not an http url", raw))
+	}
+	if s.config.AllowPrivateWebhooks {
+		return nil
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return badRequest(fmt.Errorf("cannot resolve the host of url '%s': %v", raw, err))
+	}
+	for _, ip := range ips {
+		if tracker.IsPrivateIP(ip) {
+			return badRequest(fmt.Errorf("url '%s' resolves to the private address %s", raw, ip))
+		}
+	}
+	return nil
+}
+
+// webhookRequest is the body to create a webhook. The secret is generated
+// if it is empty.
+type webhookRequest struct {
+	URL           string
+	Secret        string
+	Tokens        []web3.Address
+	Accounts      []web3.Address
+	Confirmations uint64
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func toAddressList(addrs []web3.Address) store.AddressList {
+	res := store.AddressList{}
+	for _, addr := range addrs {
+		res = append(res, addr.String())
+	}
+	return res
+}
+
+// createWebhook registers a webhook. The secret is only returned once.
+func (s *Server) createWebhook(r *http.Request) (interface{}, error) {
+	var req webhookRequest
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxWebhookBody)).Decode(&req); err != nil {
+		return nil, badRequest(fmt.Errorf("bad webhook: %v", err))
+	}
+	if err := s.validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	var err error
+	webhook := &store.Webhook{
+		URL:           req.URL,
+		Secret:        req.Secret,
+		Tokens:        toAddressList(req.Tokens),
+		Accounts:      toAddressList(req.Accounts),
+		Confirmations: req.Confirmations,
+	}
+	if webhook.ID, err = randomHex(16); err != nil {
+		return nil, err
+	}
+	if webhook.Secret == "" {
+		if webhook.Secret, err = randomHex(32); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.store.CreateWebhook(webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// listWebhooks returns the webhooks without their secrets
+func (s *Server) listWebhooks(r *http.Request) (interface{}, error) {
+	webhooks, err := s.store.ListWebhooks()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range webhooks {
+		w.Secret = ""
+	}
+	return webhooks, nil
+}
+
+// getWebhook returns a webhook without its secret
+func (s *Server) getWebhook(r *http.Request) (interface{}, error) {
+	webhook, err := s.store.GetWebhook(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+// deleteWebhook removes a webhook and its pending deliveries
+func (s *Server) deleteWebhook(r *http.Request) (interface{}, error) {
+	if err := s.store.DeleteWebhook(chi.URLParam(r, "id")); err != nil {
+		return nil, err
+	}
+	return "ok", nil
+}
+
+// listDeliveries returns the deliveries of a webhook, the most recent first.
+// The deliveries are paginated by offset.
+func (s *Server) listDeliveries(r *http.Request) (interface{}, error) {
+	id := chi.URLParam(r, "id")
+	if _, err := s.store.GetWebhook(id); err != nil {
+		return nil, err
+	}
+	query, err := parsePagination(r, 100)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.ListDeliveries(id, query)
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
+)
+
+// do sends a request to the server with the admin token
+func (s *Server) do(t *testing.T, token, method, url, body string) (int, *apiResult) {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+
+	var result apiResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, &result
+}
+
+func TestWebhooks(t *testing.T) {
+	s := memory.New()
+	srv := newTestServer(s)
+	srv.config.AdminToken = "token"
+	srv.config.AllowPrivateWebhooks = true
+
+	do := func(method, url, body string) (int, *apiResult) {
+		return srv.do(t, "token", method, url, body)
+	}
+
+	// bad webhooks
+	for _, body := range []string{
+		`{"URL": "ftp://localhost"}`,
+		`{"URL": "http://localhost", "Tokens": ["0x1"]}`,
+		`{"URL": `,
+	} {
+		if code, _ := do("POST", "/webhooks", body); code != http.StatusBadRequest {
+			t.Fatalf("expected bad request for %s but found %d", body, code)
+		}
+	}
+
+	code, result := do("POST", "/webhooks", `{"URL": "http://localhost/hook", "Accounts": ["0x0000000000000000000000000000000000000001"], "Confirmations": 3}`)
+	if code != http.StatusOK {
+		t.Fatalf("bad status %d", code)
+	}
+	created := result.Result.(map[string]interface{})
+	id := created["ID"].(string)
+	if id == "" || created["Secret"] == "" || created["Confirmations"] != float64(3) {
+		t.Fatal("bad created webhook")
+	}
+
+	// the secret is only returned when the webhook is created
+	_, result = do("GET", "/webhooks/"+id, "")
+	if _, ok := result.Result.(map[string]interface{})["Secret"]; ok {
+		t.Fatal("the secret is returned")
+	}
+	_, result = do("GET", "/webhooks", "")
+	if webhooks := result.Result.([]interface{}); len(webhooks) != 1 {
+		t.Fatal("bad webhooks")
+	}
+
+	if err := s.WriteDeliveries([]*store.Delivery{
+		{WebhookID: id, BlockNumber: 1, Status: store.DeliveryPending},
+		{WebhookID: id, BlockNumber: 2, Status: store.DeliveryPending},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, result = do("GET", "/webhooks/"+id+"/deliveries?limit=1", "")
+	deliveries := result.Result.([]interface{})
+	if len(deliveries) != 1 || deliveries[0].(map[string]interface{})["BlockNumber"] != float64(2) {
+		t.Fatal("bad deliveries")
+	}
+
+	if code, _ := do("DELETE", "/webhooks/"+id, ""); code != http.StatusOK {
+		t.Fatalf("bad status %d", code)
+	}
+	if code, _ := do("GET", "/webhooks/"+id, ""); code != http.StatusNotFound {
+		t.Fatalf("expected not found but found %d", code)
+	}
+	if code, _ := do("GET", "/webhooks/"+id+"/deliveries", ""); code != http.StatusNotFound {
+		t.Fatalf("expected not found but found %d", code)
+	}
+}
+
+func TestWebhooksAccess(t *testing.T) {
+	srv := newTestServer(memory.New())
+	body := `{"URL": "http://93.184.216.34/hook"}`
+
+	// the webhooks are disabled without an admin token
+	if code, _ := srv.do(t, "", "GET", "/webhooks", ""); code != http.StatusForbidden {
+		t.Fatalf("expected forbidden but found %d", code)
+	}
+
+	srv.config.AdminToken = "token"
+	for _, token := range []string{"", "bad"} {
+		if code, result := srv.do(t, token, "POST", "/webhooks", body); code != http.StatusUnauthorized || result.Error.Code != codeUnauthorized {
+			t.Fatalf("expected unauthorized but found %d", code)
+		}
+	}
+
+	// the webhooks cannot target private addresses
+	for _, url := range []string{
+		"http://localhost/hook",
+		"http://127.0.0.1:8545",
+		"http://[::1]/hook",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+	} {
+		if code, _ := srv.do(t, "token", "POST", "/webhooks", `{"URL": "`+url+`"}`); code != http.StatusBadRequest {
+			t.Fatalf("expected bad request for %s but found %d", url, code)
+		}
+	}
+	if code, _ := srv.do(t, "token", "POST", "/webhooks", body); code != http.StatusOK {
+		t.Fatalf("bad status %d", code)
+	}
+
+	// unless the private webhooks are allowed
+	srv.config.AllowPrivateWebhooks = true
+	if code, _ := srv.do(t, "token", "POST", "/webhooks", `{"URL": "http://127.0.0.1:8545"}`); code != http.StatusOK {
+		t.Fatalf("bad status %d", code)
+	}
+}
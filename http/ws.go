@@ -0,0 +1,111 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteTimeout is the time to write a message to the client
+	wsWriteTimeout = 10 * time.Second
+
+	// wsPingInterval is the interval of the pings that keep the
+	// connection alive. The client has to answer before the next one.
+	wsPingInterval = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	// the api is public
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// parseSubscription parses the filter of a subscription (tokens, from and
+// to)
+func parseSubscription(r *http.Request) (tracker.Filter, error) {
+	filter := tracker.Filter{}
+
+	var err error
+	if filter.Tokens, err = parseAddresses(r, "tokens"); err != nil {
+		return filter, err
+	}
+	if filter.From, err = parseAddresses(r, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseAddresses(r, "to"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// subscribe streams over a websocket the transfers that match the filter
+// as the tracker writes them and the blocks removed by a reorg. A client
+// that does not keep up with the events is disconnected.
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseSubscription(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if s.tracker == nil {
+		s.writeError(w, r, unavailable(errTrackerNotRunning))
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied to the client
+		return
+	}
+	defer conn.Close()
+
+	sub := s.tracker.Subscribe(filter)
+	defer sub.Close()
+
+	// the client does not send messages but the connection has to be read
+	// to process the control messages and to detect when it is closed
+	closeCh := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+	})
+	go func() {
+		defer close(closeCh)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case evnt, ok := <-sub.EventCh():
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				if sub.Dropped() {
+					msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer")
+					conn.WriteMessage(websocket.CloseMessage, msg)
+				}
+				return
+			}
+			if err := conn.WriteJSON(evnt); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/tracker"
+	"github.com/gorilla/websocket"
+)
+
+func TestSubscribe(t *testing.T) {
+	broker := tracker.NewBroker()
+
+	s := newTestServer(&mockStore{})
+	s.tracker = &mockTracker{broker: broker}
+
+	srv := httptest.NewServer(s.router)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?tokens=0x0000000000000000000000000000000000000001"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	token1 := "0x0000000000000000000000000000000000000001"
+	token2 := "0x0000000000000000000000000000000000000002"
+
+	// publish until the subscription is registered
+	readCh := make(chan *tracker.Event, 16)
+	go func() {
+		for {
+			var evnt tracker.Event
+			if err := conn.ReadJSON(&evnt); err != nil {
+				close(readCh)
+				return
+			}
+			readCh <- &evnt
+		}
+	}()
+	added := &tracker.Event{
+		Type: tracker.EventAdded,
+		Transfers: []*store.Transfer{
+			{Addr: token1, Value: "1"},
+			{Addr: token2, Value: "2"},
+		},
+	}
+	var evnt *tracker.Event
+	for evnt == nil {
+		broker.Publish(added)
+		select {
+		case evnt = <-readCh:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if evnt.Type != tracker.EventAdded || len(evnt.Transfers) != 1 || evnt.Transfers[0].Addr != token1 {
+		t.Fatal("bad added event")
+	}
+
+	broker.Publish(&tracker.Event{Type: tracker.EventRemoved, BlockHash: "0x1"})
+	for evnt.Type == tracker.EventAdded {
+		select {
+		case evnt = <-readCh:
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	if evnt.Type != tracker.EventRemoved || evnt.BlockHash != "0x1" {
+		t.Fatal("bad removed event")
+	}
+}
+
+func TestSubscribeBadFilter(t *testing.T) {
+	s := newTestServer(&mockStore{})
+	s.tracker = &mockTracker{broker: tracker.NewBroker()}
+
+	if status, _ := s.get(t, "/ws?from=0x1234"); status != http.StatusBadRequest {
+		t.Fatalf("expected bad request but found %d", status)
+	}
+}
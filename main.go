@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os/signal"
+	"sort"
+	"strings"
 	"time"
 
 	"os"
@@ -15,7 +17,9 @@ import (
 	"github.com/hashicorp/hcl"
 	"github.com/imdario/mergo"
 
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
 	"github.com/ferranbt/go-eth-token-tracker/store/postgresql"
+	"github.com/ferranbt/go-eth-token-tracker/store/sqlite"
 
 	"github.com/ferranbt/go-eth-token-tracker/http"
 	"github.com/ferranbt/go-eth-token-tracker/store"
@@ -45,7 +49,9 @@ func defaultConfig() *Config {
 	return &Config{
 		HTTP:    http.DefaultConfig(),
 		Tracker: tracker.DefaultConfig(),
-		Storage: map[string]interface{}{},
+		Storage: map[string]interface{}{
+			"backend": "postgresql",
+		},
 	}
 }
 
@@ -58,14 +64,20 @@ func builtConfig() (*Config, error) {
 		Storage: map[string]interface{}{},
 	}
 
-	var configPath, dbEndpoint string
+	var configPath, dbEndpoint, storageBackend string
 
 	flag.StringVar(&cliConfig.HTTP.Addr, "http-addr", "", "")
+	flag.Uint64Var(&cliConfig.HTTP.MaxLag, "max-lag", 0, "")
+	flag.BoolVar(&cliConfig.HTTP.Metrics, "metrics", false, "")
 	flag.StringVar(&cliConfig.Tracker.Endpoint, "jsonrpc-endpoint", "", "")
 	flag.StringVar(&cliConfig.Tracker.BoltDBPath, "boltdb-path", "", "")
 	flag.StringVar(&dbEndpoint, "db-endpoint", "", "")
+	flag.StringVar(&storageBackend, "storage", "", "")
 	flag.Int64Var(&cliConfig.Tracker.BatchSize, "batch-size", 0, "")
 	flag.BoolVar(&cliConfig.Tracker.ProgressBar, "progress-bar", false, "")
+	flag.Uint64Var(&cliConfig.Tracker.Confirmations, "confirmations", 0, "")
+	flag.IntVar(&cliConfig.Tracker.Workers, "workers", 0, "")
+	flag.Uint64Var(&cliConfig.Tracker.ShardSize, "shard-size", 0, "")
 	flag.StringVar(&configPath, "config", "", "")
 
 	flag.Parse()
@@ -73,6 +85,17 @@ func builtConfig() (*Config, error) {
 	if dbEndpoint != "" {
 		cliConfig.Storage["endpoint"] = dbEndpoint
 	}
+	if strings.Contains(cliConfig.Tracker.Endpoint, ",") {
+		// each endpoint is the fallback of the previous one
+		cliConfig.Tracker.Endpoints = map[string]tracker.EndpointConfig{}
+		for indx, endpoint := range strings.Split(cliConfig.Tracker.Endpoint, ",") {
+			cliConfig.Tracker.Endpoints[strings.TrimSpace(endpoint)] = tracker.EndpointConfig{Priority: indx}
+		}
+		cliConfig.Tracker.Endpoint = ""
+	}
+	if storageBackend != "" {
+		cliConfig.Storage["backend"] = storageBackend
+	}
 
 	if configPath != "" {
 		data, err := ioutil.ReadFile(configPath)
@@ -108,40 +131,55 @@ func run() error {
 
 	logger := log.New(os.Stderr, "", log.LstdFlags)
 
-	storageFactory := builtin["postgresql"]
-	store, err := storageFactory(config.Storage)
+	storageFactory, err := lookupStorage(config.Storage)
+	if err != nil {
+		return err
+	}
+	storage, err := storageFactory(config.Storage)
 	if err != nil {
 		return fmt.Errorf("failed to build storage: %v", err)
 	}
+	// the backend is validated by lookupStorage
+	metricStore := store.WithMetrics(config.Storage["backend"].(string), storage)
+
+	// the webhooks are validated by the http server and sent by the tracker
+	config.Tracker.AllowPrivateWebhooks = config.HTTP.AllowPrivateWebhooks
 
-	httpServer, err := http.NewServer(logger, config.HTTP, store)
+	tracker, err := tokentracker.NewTokenTracker(logger, config.Tracker, metricStore)
 	if err != nil {
-		return fmt.Errorf("failed to build http server: %v", err)
+		return fmt.Errorf("failed to start tracker: %v", err)
 	}
 
-	tracker, err := tokentracker.NewTokenTracker(logger, config.Tracker, store)
+	httpServer, err := http.NewServer(logger, config.HTTP, metricStore, tracker)
 	if err != nil {
-		return fmt.Errorf("failed to start tracker: %v", err)
+		return fmt.Errorf("failed to build http server: %v", err)
 	}
 
+	syncErrCh := make(chan error, 1)
 	go func() {
-		tracker.Sync(context.Background())
+		syncErrCh <- tracker.Sync(context.Background())
 	}()
 
 	close := func() {
 		httpServer.Stop()
 		tracker.Stop()
 	}
-	handleSignals(close)
-	return nil
+	return handleSignals(close, syncErrCh)
 }
 
-func handleSignals(cancelFn func()) int {
+// handleSignals stops the process on a signal or if the tracker fails. It
+// returns the error of the tracker.
+func handleSignals(cancelFn func(), syncErrCh <-chan error) error {
 	signalCh := make(chan os.Signal, 4)
 	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
+	var syncErr error
 	select {
 	case <-signalCh:
+	case syncErr = <-syncErrCh:
+		if syncErr != nil {
+			syncErr = fmt.Errorf("tracker failed: %v", syncErr)
+		}
 	}
 
 	gracefulCh := make(chan struct{})
@@ -152,12 +190,10 @@ func handleSignals(cancelFn func()) int {
 
 	select {
 	case <-signalCh:
-		return 1
 	case <-time.After(10 * time.Second):
-		return 1
 	case <-gracefulCh:
-		return 0
 	}
+	return syncErr
 }
 
 // Factory is the factory method for the database
@@ -172,6 +208,29 @@ func register(name string, f Factory) {
 	builtin[name] = f
 }
 
+func lookupStorage(config map[string]interface{}) (Factory, error) {
+	backendRaw, ok := config["backend"]
+	if !ok {
+		return nil, fmt.Errorf("storage backend not set")
+	}
+	backend, ok := backendRaw.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert storage backend to string")
+	}
+	f, ok := builtin[backend]
+	if !ok {
+		names := []string{}
+		for name := range builtin {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("storage backend '%s' not found, available backends: %s", backend, strings.Join(names, ", "))
+	}
+	return f, nil
+}
+
 func init() {
 	register("postgresql", postgresql.Factory)
+	register("memory", memory.Factory)
+	register("sqlite", sqlite.Factory)
 }
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/umbracle/go-web3"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// LogCursor is the position of the last transfer of a page. The next page
+// starts after it. Value is only set for the transfers sorted by value.
+type LogCursor struct {
+	BlockNumber uint64
+	LogIndex    uint64
+	BatchIndex  uint64
+	Value       string
+}
+
+// Encode returns the cursor as an opaque string
+func (c *LogCursor) Encode() string {
+	raw := fmt.Sprintf("%d.%d.%d", c.BlockNumber, c.LogIndex, c.BatchIndex)
+	if c.Value != "" {
+		raw += "." + c.Value
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeLogCursor decodes a cursor returned by LogCursor.Encode
+func DecodeLogCursor(cursor string) (*LogCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, ErrInvalidCursor
+	}
+	vals := make([]uint64, 3)
+	for indx, part := range parts[:3] {
+		if vals[indx], err = strconv.ParseUint(part, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	c := &LogCursor{
+		BlockNumber: vals[0],
+		LogIndex:    vals[1],
+		BatchIndex:  vals[2],
+	}
+	if len(parts) == 4 {
+		// the values are decimal numbers
+		value, ok := new(big.Int).SetString(parts[3], 10)
+		if !ok || value.Sign() < 0 || value.String() != parts[3] {
+			return nil, ErrInvalidCursor
+		}
+		c.Value = parts[3]
+	}
+	return c, nil
+}
+
+// DecodeTransfersCursor decodes the cursor of a transfers filter. It returns
+// nil if the filter has no cursor. A cursor is only valid for the sort field
+// of the query that returned it.
+func DecodeTransfersCursor(filter TransfersFilter) (*LogCursor, error) {
+	if filter.Cursor == "" {
+		return nil, nil
+	}
+	c, err := DecodeLogCursor(filter.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if (filter.Sort == SortValue) != (c.Value != "") {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// EncodeTokenCursor returns the cursor of the last token of a page as an
+// opaque string
+func EncodeTokenCursor(token string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(token))
+}
+
+// DecodeTokenCursor decodes a cursor returned by EncodeTokenCursor
+func DecodeTokenCursor(cursor string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	var token web3.Address
+	if err := token.UnmarshalText(raw); err != nil {
+		return "", ErrInvalidCursor
+	}
+	return token.String(), nil
+}
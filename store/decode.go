@@ -0,0 +1,279 @@
+package store
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/abi"
+	"github.com/umbracle/go-web3/contract/builtin/erc20"
+)
+
+var (
+	transferEvent = erc20.ERC20Abi().Events["Transfer"]
+)
+
+var erc721Abi = `[
+	{
+		"anonymous": false,
+		"inputs": [
+			{"indexed": true, "name": "from", "type": "address"},
+			{"indexed": true, "name": "to", "type": "address"},
+			{"indexed": true, "name": "tokenId", "type": "uint256"}
+		],
+		"name": "Transfer",
+		"type": "event"
+	}
+]`
+
+var erc1155Abi = `[
+	{
+		"anonymous": false,
+		"inputs": [
+			{"indexed": true, "name": "operator", "type": "address"},
+			{"indexed": true, "name": "from", "type": "address"},
+			{"indexed": true, "name": "to", "type": "address"},
+			{"indexed": false, "name": "id", "type": "uint256"},
+			{"indexed": false, "name": "value", "type": "uint256"}
+		],
+		"name": "TransferSingle",
+		"type": "event"
+	},
+	{
+		"anonymous": false,
+		"inputs": [
+			{"indexed": true, "name": "operator", "type": "address"},
+			{"indexed": true, "name": "from", "type": "address"},
+			{"indexed": true, "name": "to", "type": "address"},
+			{"indexed": false, "name": "ids", "type": "uint256[]"},
+			{"indexed": false, "name": "values", "type": "uint256[]"}
+		],
+		"name": "TransferBatch",
+		"type": "event"
+	}
+]`
+
+var (
+	nftTransferEvent = mustNewABI(erc721Abi).Events["Transfer"]
+
+	transferSingleEvent = mustNewABI(erc1155Abi).Events["TransferSingle"]
+	transferBatchEvent  = mustNewABI(erc1155Abi).Events["TransferBatch"]
+)
+
+// Topics returns the topics of the events decoded by the store
+func Topics() []web3.Hash {
+	return []web3.Hash{
+		transferEvent.ID(),
+		transferSingleEvent.ID(),
+		transferBatchEvent.ID(),
+	}
+}
+
+func mustNewABI(s string) *abi.ABI {
+	a, err := abi.NewABI(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func logPosition(log *web3.Log) LogPosition {
+	return LogPosition{
+		BlockHash:   log.BlockHash.String(),
+		TxnHash:     log.TransactionHash.String(),
+		BlockNumber: log.BlockNumber,
+		LogIndex:    log.LogIndex,
+		TxnIndex:    log.TransactionIndex,
+	}
+}
+
+// ParseTransfer decodes an ERC20 Transfer log. It returns nil if the log
+// is not a standard ERC20 transfer.
+func ParseTransfer(log *web3.Log) (*Transfer, error) {
+	if len(log.Topics) != 3 || log.Topics[0] != transferEvent.ID() {
+		// non-standard erc20 token
+		return nil, nil
+	}
+	if len(log.Data) != 32 {
+		// malformed value
+		return nil, nil
+	}
+	vals, err := abi.ParseLog(transferEvent.Inputs, log)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := decodeAddress(vals, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := decodeAddress(vals, "to")
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodeBigInt(vals, "value")
+	if err != nil {
+		return nil, err
+	}
+	t := &Transfer{
+		LogPosition: logPosition(log),
+		Addr:        log.Address.String(),
+		From:        from.String(),
+		To:          to.String(),
+		Value:       value.String(),
+	}
+	return t, nil
+}
+
+// ParseNFTTransfer decodes an ERC721 Transfer log. It returns nil if the log
+// is not an ERC721 transfer.
+func ParseNFTTransfer(log *web3.Log) (*NFTTransfer, error) {
+	if len(log.Topics) != 4 || log.Topics[0] != nftTransferEvent.ID() {
+		return nil, nil
+	}
+	// all the arguments are indexed
+	vals, err := abi.ParseTopics(nftTransferEvent.Inputs, log.Topics[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	from, ok := vals[0].(web3.Address)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert 'from' to address")
+	}
+	to, ok := vals[1].(web3.Address)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert 'to' to address")
+	}
+	tokenID, ok := vals[2].(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert 'tokenId' to big.Int")
+	}
+	t := &NFTTransfer{
+		LogPosition: logPosition(log),
+		Addr:        log.Address.String(),
+		From:        from.String(),
+		To:          to.String(),
+		TokenID:     tokenID.String(),
+	}
+	return t, nil
+}
+
+// ParseMultiTransfers decodes an ERC1155 TransferSingle or TransferBatch log
+// into one transfer for each token id. It returns nil if the log is not an
+// ERC1155 transfer or if it is malformed.
+func ParseMultiTransfers(log *web3.Log) ([]*MultiTransfer, error) {
+	if len(log.Topics) != 4 {
+		return nil, nil
+	}
+
+	var ids, values []*big.Int
+	switch log.Topics[0] {
+	case transferSingleEvent.ID():
+		vals, ok := parseLog(transferSingleEvent, log)
+		if !ok {
+			return nil, nil
+		}
+		id, err := decodeBigInt(vals, "id")
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeBigInt(vals, "value")
+		if err != nil {
+			return nil, err
+		}
+		ids, values = []*big.Int{id}, []*big.Int{value}
+
+	case transferBatchEvent.ID():
+		vals, ok := parseLog(transferBatchEvent, log)
+		if !ok {
+			return nil, nil
+		}
+		if ids, ok = vals["ids"].([]*big.Int); !ok {
+			return nil, fmt.Errorf("cannot convert 'ids' to []big.Int")
+		}
+		if values, ok = vals["values"].([]*big.Int); !ok {
+			return nil, fmt.Errorf("cannot convert 'values' to []big.Int")
+		}
+		if len(ids) != len(values) {
+			// malformed batch
+			return nil, nil
+		}
+
+	default:
+		return nil, nil
+	}
+
+	// the addresses are indexed
+	addrs, err := abi.ParseTopics(transferSingleEvent.Inputs[:3], log.Topics[1:])
+	if err != nil {
+		return nil, err
+	}
+	operator, ok := addrs[0].(web3.Address)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert 'operator' to address")
+	}
+	from, ok := addrs[1].(web3.Address)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert 'from' to address")
+	}
+	to, ok := addrs[2].(web3.Address)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert 'to' to address")
+	}
+
+	transfers := []*MultiTransfer{}
+	for indx := range ids {
+		transfers = append(transfers, &MultiTransfer{
+			LogPosition: logPosition(log),
+			BatchIndex:  uint64(indx),
+			Addr:        log.Address.String(),
+			Operator:    operator.String(),
+			From:        from.String(),
+			To:          to.String(),
+			TokenID:     ids[indx].String(),
+			Value:       values[indx].String(),
+		})
+	}
+	return transfers, nil
+}
+
+// parseLog decodes the log of an event. The abi decoder does not validate
+// the sizes of the data and might panic, it returns false if the data
+// cannot be decoded.
+func parseLog(event *abi.Event, log *web3.Log) (vals map[string]interface{}, ok bool) {
+	defer func() {
+		if recover() != nil {
+			vals, ok = nil, false
+		}
+	}()
+	vals, err := abi.ParseLog(event.Inputs, log)
+	if err != nil {
+		return nil, false
+	}
+	return vals, true
+}
+
+func decodeAddress(vals map[string]interface{}, attr string) (web3.Address, error) {
+	val, ok := vals[attr]
+	if !ok {
+		return web3.Address{}, fmt.Errorf("key '%s' not found", attr)
+	}
+	valStr, ok := val.(web3.Address)
+	if !ok {
+		return web3.Address{}, fmt.Errorf("cannot convert '%s' to string", attr)
+	}
+	return valStr, nil
+}
+
+func decodeBigInt(vals map[string]interface{}, attr string) (*big.Int, error) {
+	val, ok := vals[attr]
+	if !ok {
+		return nil, fmt.Errorf("key '%s' not found", attr)
+	}
+	valStr, ok := val.(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert '%s' to big.Int", attr)
+	}
+	return valStr, nil
+}
@@ -0,0 +1,562 @@
+package memory
+
+import (
+	"math/big"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+// Factory is the factory method for the in-memory store
+func Factory(config map[string]interface{}) (store.Store, error) {
+	return New(), nil
+}
+
+// Store is an in-memory store for the tracker
+type Store struct {
+	lock      sync.RWMutex
+	tokens    []*store.Token
+	tokensSet map[string]*store.Token
+	transfers []*store.Transfer
+
+	// index of the (block hash, log index) pairs already stored
+	logs map[string]struct{}
+
+	// blocks indexed by hash
+	blocks map[string]*store.Block
+
+	// balances indexed by token and account
+	balances map[string]map[string]*big.Int
+
+	nftTransfers []*store.NFTTransfer
+
+	// owners of the erc721 tokens indexed by token and token id
+	nftOwners map[string]map[string]string
+
+	multiTransfers []*store.MultiTransfer
+
+	// balances of the erc1155 tokens indexed by token, token id and account
+	multiBalances map[string]map[string]map[string]*big.Int
+
+	webhooks   map[string]*store.Webhook
+	deliveries []*store.Delivery
+	deliveryID uint64
+}
+
+// New creates a new in-memory store
+func New() *Store {
+	return &Store{
+		tokens:    []*store.Token{},
+		tokensSet: map[string]*store.Token{},
+		transfers: []*store.Transfer{},
+		logs:      map[string]struct{}{},
+		blocks:    map[string]*store.Block{},
+		balances:  map[string]map[string]*big.Int{},
+
+		nftTransfers: []*store.NFTTransfer{},
+		nftOwners:    map[string]map[string]string{},
+
+		multiTransfers: []*store.MultiTransfer{},
+		multiBalances:  map[string]map[string]map[string]*big.Int{},
+
+		webhooks:   map[string]*store.Webhook{},
+		deliveries: []*store.Delivery{},
+	}
+}
+
+// WriteBlocks writes the blocks of the receipts
+func (s *Store) WriteBlocks(blocks []*store.Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, b := range blocks {
+		elem := *b
+		s.blocks[b.Hash] = &elem
+	}
+	return nil
+}
+
+// timestamp returns the time of the block or zero if it is not stored
+func (s *Store) timestamp(blockHash string) uint64 {
+	if b, ok := s.blocks[blockHash]; ok {
+		return b.Timestamp
+	}
+	return 0
+}
+
+// WriteReceipt writes a new receipt and returns the logs written. Logs that
+// are already stored are ignored
+func (s *Store) WriteReceipt(logs []*web3.Log) ([]*web3.Log, error) {
+	// decode all the logs first so that the write is atomic. The index of
+	// the log of every transfer is kept to return the logs written.
+	transfers := []*store.Transfer{}
+	transferLogs := []int{}
+	changes := [][]*store.Balance{}
+	nftTransfers := []*store.NFTTransfer{}
+	nftLogs := []int{}
+	multiTransfers := [][]*store.MultiTransfer{}
+	multiLogs := []int{}
+	for indx, log := range logs {
+		t, err := store.ParseTransfer(log)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			c, err := store.BalanceChanges(t, false)
+			if err != nil {
+				return nil, err
+			}
+			transfers = append(transfers, t)
+			transferLogs = append(transferLogs, indx)
+			changes = append(changes, c)
+			continue
+		}
+		n, err := store.ParseNFTTransfer(log)
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nftTransfers = append(nftTransfers, n)
+			nftLogs = append(nftLogs, indx)
+			continue
+		}
+		m, err := store.ParseMultiTransfers(log)
+		if err != nil {
+			return nil, err
+		}
+		if len(m) != 0 {
+			for _, t := range m {
+				// validate the values before the write
+				if _, err := store.MultiBalanceChanges(t, false); err != nil {
+					return nil, err
+				}
+			}
+			multiTransfers = append(multiTransfers, m)
+			multiLogs = append(multiLogs, indx)
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	written := make([]bool, len(logs))
+	for indx, t := range transfers {
+		if !s.addLog(t.LogPosition) {
+			continue
+		}
+		written[transferLogs[indx]] = true
+		s.addToken(t.Addr)
+		t.Timestamp = s.timestamp(t.BlockHash)
+		s.transfers = append(s.transfers, t)
+		s.updateBalances(changes[indx])
+	}
+	for indx, t := range nftTransfers {
+		if !s.addLog(t.LogPosition) {
+			continue
+		}
+		written[nftLogs[indx]] = true
+		s.addToken(t.Addr)
+		t.Timestamp = s.timestamp(t.BlockHash)
+		s.nftTransfers = append(s.nftTransfers, t)
+		s.setNFTOwner(t.Addr, t.TokenID, t.To)
+	}
+	for indx, m := range multiTransfers {
+		// all the transfers of a batch share the same log
+		if !s.addLog(m[0].LogPosition) {
+			continue
+		}
+		written[multiLogs[indx]] = true
+		for _, t := range m {
+			s.addToken(t.Addr)
+			t.Timestamp = s.timestamp(t.BlockHash)
+			s.multiTransfers = append(s.multiTransfers, t)
+			s.updateMultiBalances(t, false)
+		}
+	}
+
+	res := []*web3.Log{}
+	for indx, log := range logs {
+		if written[indx] {
+			res = append(res, log)
+		}
+	}
+	return res, nil
+}
+
+// addLog indexes the position of a log. It returns false if the log is
+// already stored
+func (s *Store) addLog(p store.LogPosition) bool {
+	key := logKey(p)
+	if _, ok := s.logs[key]; ok {
+		return false
+	}
+	s.logs[key] = struct{}{}
+	return true
+}
+
+func (s *Store) addToken(token string) {
+	if _, ok := s.tokensSet[token]; !ok {
+		t := &store.Token{Addr: token}
+		s.tokensSet[token] = t
+		s.tokens = append(s.tokens, t)
+	}
+}
+
+func (s *Store) updateBalances(changes []*store.Balance) {
+	for _, b := range changes {
+		accounts, ok := s.balances[b.Token]
+		if !ok {
+			accounts = map[string]*big.Int{}
+			s.balances[b.Token] = accounts
+		}
+		value, _ := new(big.Int).SetString(b.Value, 10)
+		if prev, ok := accounts[b.Account]; ok {
+			value.Add(value, prev)
+		}
+		if value.Sign() == 0 {
+			delete(accounts, b.Account)
+		} else {
+			accounts[b.Account] = value
+		}
+	}
+}
+
+// RemoveReceipts removes the receipts by block hash
+func (s *Store) RemoveReceipts(blockHash web3.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	transfers := []*store.Transfer{}
+	changes := []*store.Balance{}
+	for _, t := range s.transfers {
+		if t.BlockHash != blockHash.String() {
+			transfers = append(transfers, t)
+			continue
+		}
+		c, err := store.BalanceChanges(t, true)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, c...)
+	}
+	for _, t := range s.transfers {
+		if t.BlockHash == blockHash.String() {
+			delete(s.logs, logKey(t.LogPosition))
+		}
+	}
+	s.transfers = transfers
+	s.updateBalances(changes)
+	s.removeNFTTransfers(blockHash)
+	s.removeMultiTransfers(blockHash)
+	delete(s.blocks, blockHash.String())
+	return nil
+}
+
+// ConfirmTransfers marks as confirmed the transfers up to the block
+func (s *Store) ConfirmTransfers(blockNumber uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, t := range s.transfers {
+		if t.BlockNumber <= blockNumber {
+			t.Confirmed = true
+		}
+	}
+	for _, t := range s.nftTransfers {
+		if t.BlockNumber <= blockNumber {
+			t.Confirmed = true
+		}
+	}
+	for _, t := range s.multiTransfers {
+		if t.BlockNumber <= blockNumber {
+			t.Confirmed = true
+		}
+	}
+	return nil
+}
+
+// Close closes the storage
+func (s *Store) Close() error {
+	return nil
+}
+
+// Ping checks that the store can be reached
+func (s *Store) Ping() error {
+	// the memory store is always reachable
+	return nil
+}
+
+// ListTokens returns the list of registered tokens
+func (s *Store) ListTokens(p store.QueryPagination) ([]*store.Token, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	after := ""
+	if p.Cursor != "" {
+		token, err := store.DecodeTokenCursor(p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = token
+	}
+
+	matches := []*store.Token{}
+	for _, t := range s.tokens {
+		if t.Addr > after {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Addr < matches[j].Addr
+	})
+
+	low, high := paginate(p, len(matches))
+
+	tokens := []*store.Token{}
+	for _, t := range matches[low:high] {
+		elem := *t
+		tokens = append(tokens, &elem)
+	}
+	return tokens, nil
+}
+
+// GetToken returns a token and its metadata
+func (s *Store) GetToken(token web3.Address) (*store.Token, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	t, ok := s.tokensSet[token.String()]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	elem := *t
+	return &elem, nil
+}
+
+// WriteTokenMetadata updates the metadata of a token
+func (s *Store) WriteTokenMetadata(token *store.Token) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if t, ok := s.tokensSet[token.Addr]; ok {
+		*t = *token
+	}
+	return nil
+}
+
+// GetTokenTransfers returns the transfers given a filter
+func (s *Store) GetTokenTransfers(filter store.TransfersFilter) ([]*store.Transfer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sorter, err := newSorter(filter)
+	if err != nil {
+		return nil, err
+	}
+	key := func(t *store.Transfer) sortKey {
+		return sorter.key(t.LogPosition, 0, t.Value)
+	}
+
+	matches := []*store.Transfer{}
+	for _, t := range s.transfers {
+		if match(filter, t.LogPosition, t.Addr, t.From, t.To) && sorter.afterCursor(key(t)) {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return sorter.less(key(matches[i]), key(matches[j]))
+	})
+
+	low, high := paginate(filter.QueryPagination, len(matches))
+
+	transfers := []*store.Transfer{}
+	for _, t := range matches[low:high] {
+		elem := *t
+		transfers = append(transfers, &elem)
+	}
+	return transfers, nil
+}
+
+// match returns true if the position, token, from and to values of a
+// transfer match the filter
+func match(filter store.TransfersFilter, p store.LogPosition, token, from, to string) bool {
+	return contains(addressSet(filter.Tokens), token) &&
+		contains(addressSet(filter.From), from) &&
+		contains(addressSet(filter.To), to) &&
+		inRange(p.BlockNumber, filter.FromBlock, filter.ToBlock) &&
+		inRange(p.Timestamp, filter.FromTime, filter.ToTime) &&
+		(filter.ToTime == 0 || p.Timestamp != 0) &&
+		filter.Status.Match(p.Confirmed)
+}
+
+// inRange returns true if the value is in the inclusive range. A zero bound
+// is not applied.
+func inRange(val, low, high uint64) bool {
+	return val >= low && (high == 0 || val <= high)
+}
+
+// GetBalances returns the balances of an account
+func (s *Store) GetBalances(account web3.Address, tokens []web3.Address) ([]*store.Balance, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	filter := addressSet(tokens)
+
+	balances := []*store.Balance{}
+	for token, accounts := range s.balances {
+		if !contains(filter, token) {
+			continue
+		}
+		value, ok := accounts[account.String()]
+		if !ok {
+			continue
+		}
+		balances = append(balances, &store.Balance{
+			Token:   token,
+			Account: account.String(),
+			Value:   value.String(),
+		})
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Token < balances[j].Token
+	})
+	return balances, nil
+}
+
+// GetBalanceAt returns the balance of an account after a given block
+func (s *Store) GetBalanceAt(token, account web3.Address, blockNumber uint64) (*store.Balance, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	transfers := []*store.Transfer{}
+	for _, t := range s.transfers {
+		if t.Addr == token.String() && t.BlockNumber <= blockNumber {
+			transfers = append(transfers, t)
+		}
+	}
+
+	balance := big.NewInt(0)
+	if err := store.ApplyTransfers(account.String(), balance, transfers); err != nil {
+		return nil, err
+	}
+	b := &store.Balance{
+		Token:   token.String(),
+		Account: account.String(),
+		Value:   balance.String(),
+	}
+	return b, nil
+}
+
+func logKey(p store.LogPosition) string {
+	return p.BlockHash + ":" + strconv.FormatUint(p.LogIndex, 10)
+}
+
+// sortKey is the position of a transfer in the sort order of a filter
+type sortKey struct {
+	value      string
+	block      uint64
+	logIndex   uint64
+	batchIndex uint64
+}
+
+// sorter sorts the transfers as requested by a filter
+type sorter struct {
+	byValue bool
+	desc    bool
+	cursor  *sortKey
+}
+
+func newSorter(filter store.TransfersFilter) (*sorter, error) {
+	s := &sorter{
+		byValue: filter.Sort == store.SortValue,
+		desc:    filter.Desc,
+	}
+	cursor, err := store.DecodeTransfersCursor(filter)
+	if err != nil {
+		return nil, err
+	}
+	if cursor != nil {
+		s.cursor = &sortKey{cursor.Value, cursor.BlockNumber, cursor.LogIndex, cursor.BatchIndex}
+	}
+	return s, nil
+}
+
+// key returns the sort key of a transfer. The value is only used if the
+// transfers are sorted by value.
+func (s *sorter) key(p store.LogPosition, batchIndex uint64, value string) sortKey {
+	if !s.byValue {
+		value = ""
+	}
+	return sortKey{value, p.BlockNumber, p.LogIndex, batchIndex}
+}
+
+// less returns true if the key a comes before b
+func (s *sorter) less(a, b sortKey) bool {
+	if s.desc {
+		a, b = b, a
+	}
+	// the values are decimal numbers
+	if len(a.value) != len(b.value) {
+		return len(a.value) < len(b.value)
+	}
+	if a.value != b.value {
+		return a.value < b.value
+	}
+	if a.block != b.block {
+		return a.block < b.block
+	}
+	if a.logIndex != b.logIndex {
+		return a.logIndex < b.logIndex
+	}
+	return a.batchIndex < b.batchIndex
+}
+
+// afterCursor returns true if the key comes after the cursor
+func (s *sorter) afterCursor(k sortKey) bool {
+	return s.cursor == nil || s.less(*s.cursor, k)
+}
+
+// paginate returns the bounds of the page in a list of size elements. As in
+// the sql stores, the offset is only applied if there is a limit and no
+// cursor. Negative values are clamped so that the bounds are always valid.
+func paginate(p store.QueryPagination, size int) (int, int) {
+	if p.Limit <= 0 {
+		return 0, size
+	}
+	low := p.Offset
+	if p.Cursor != "" || low < 0 {
+		low = 0
+	}
+	if low > size {
+		low = size
+	}
+	high := low + p.Limit
+	if high > size || high < low {
+		high = size
+	}
+	return low, high
+}
+
+func addressSet(addrs []web3.Address) map[string]struct{} {
+	if len(addrs) == 0 {
+		return nil
+	}
+	res := map[string]struct{}{}
+	for _, addr := range addrs {
+		res[addr.String()] = struct{}{}
+	}
+	return res
+}
+
+// contains returns true if the value is in the set. An empty set matches
+// every value.
+func contains(set map[string]struct{}, val string) bool {
+	if set == nil {
+		return true
+	}
+	_, ok := set[val]
+	return ok
+}
@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+)
+
+func testMemory(t *testing.T) (store.Store, func()) {
+	return New(), func() {}
+}
+
+func TestStore(t *testing.T) {
+	store.TestStore(t, testMemory)
+}
+
+func TestPaginate(t *testing.T) {
+	cases := []struct {
+		p         store.QueryPagination
+		low, high int
+	}{
+		{store.QueryPagination{}, 0, 10},
+		{store.QueryPagination{Limit: 3, Offset: 2}, 2, 5},
+		{store.QueryPagination{Limit: 3, Offset: 20}, 10, 10},
+		{store.QueryPagination{Limit: 3, Offset: -1}, 0, 3},
+		{store.QueryPagination{Limit: -1, Offset: 2}, 0, 10},
+		{store.QueryPagination{Limit: int(^uint(0) >> 1), Offset: 2}, 2, 10},
+	}
+	for _, c := range cases {
+		low, high := paginate(c.p, 10)
+		if low != c.low || high != c.high {
+			t.Fatalf("%v: expected [%d, %d) but found [%d, %d)", c.p, c.low, c.high, low, high)
+		}
+	}
+}
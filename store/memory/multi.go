@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+// updateMultiBalances applies the changes of a transfer. The values of the
+// transfer are validated before it is stored.
+func (s *Store) updateMultiBalances(t *store.MultiTransfer, revert bool) {
+	changes, _ := store.MultiBalanceChanges(t, revert)
+	for _, b := range changes {
+		ids, ok := s.multiBalances[b.Token]
+		if !ok {
+			ids = map[string]map[string]*big.Int{}
+			s.multiBalances[b.Token] = ids
+		}
+		accounts, ok := ids[b.TokenID]
+		if !ok {
+			accounts = map[string]*big.Int{}
+			ids[b.TokenID] = accounts
+		}
+		value, _ := new(big.Int).SetString(b.Value, 10)
+		if prev, ok := accounts[b.Account]; ok {
+			value.Add(value, prev)
+		}
+		if value.Sign() == 0 {
+			delete(accounts, b.Account)
+		} else {
+			accounts[b.Account] = value
+		}
+	}
+}
+
+func (s *Store) removeMultiTransfers(blockHash web3.Hash) {
+	transfers := []*store.MultiTransfer{}
+	for _, t := range s.multiTransfers {
+		if t.BlockHash == blockHash.String() {
+			delete(s.logs, logKey(t.LogPosition))
+			s.updateMultiBalances(t, true)
+		} else {
+			transfers = append(transfers, t)
+		}
+	}
+	s.multiTransfers = transfers
+}
+
+// GetMultiTransfers returns the ERC1155 transfers given a filter
+func (s *Store) GetMultiTransfers(filter store.TransfersFilter) ([]*store.MultiTransfer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sorter, err := newSorter(filter)
+	if err != nil {
+		return nil, err
+	}
+	key := func(t *store.MultiTransfer) sortKey {
+		return sorter.key(t.LogPosition, t.BatchIndex, t.Value)
+	}
+
+	matches := []*store.MultiTransfer{}
+	for _, t := range s.multiTransfers {
+		if match(filter, t.LogPosition, t.Addr, t.From, t.To) && sorter.afterCursor(key(t)) {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return sorter.less(key(matches[i]), key(matches[j]))
+	})
+
+	low, high := paginate(filter.QueryPagination, len(matches))
+
+	transfers := []*store.MultiTransfer{}
+	for _, t := range matches[low:high] {
+		elem := *t
+		transfers = append(transfers, &elem)
+	}
+	return transfers, nil
+}
+
+// GetMultiBalances returns the ERC1155 balances of an account
+func (s *Store) GetMultiBalances(account web3.Address, tokens []web3.Address) ([]*store.MultiBalance, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	filter := addressSet(tokens)
+
+	balances := []*store.MultiBalance{}
+	for token, ids := range s.multiBalances {
+		if !contains(filter, token) {
+			continue
+		}
+		for tokenID, accounts := range ids {
+			value, ok := accounts[account.String()]
+			if !ok {
+				continue
+			}
+			balances = append(balances, &store.MultiBalance{
+				Token:   token,
+				TokenID: tokenID,
+				Account: account.String(),
+				Value:   value.String(),
+			})
+		}
+	}
+	// the token ids are decimal numbers
+	sort.Slice(balances, func(i, j int) bool {
+		a, b := balances[i], balances[j]
+		if a.Token != b.Token {
+			return a.Token < b.Token
+		}
+		if len(a.TokenID) != len(b.TokenID) {
+			return len(a.TokenID) < len(b.TokenID)
+		}
+		return a.TokenID < b.TokenID
+	})
+	return balances, nil
+}
@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+func (s *Store) setNFTOwner(token, tokenID, owner string) {
+	owners, ok := s.nftOwners[token]
+	if !ok {
+		owners = map[string]string{}
+		s.nftOwners[token] = owners
+	}
+	if owner == store.ZeroAddress {
+		// the token was burnt
+		delete(owners, tokenID)
+	} else {
+		owners[tokenID] = owner
+	}
+}
+
+func (s *Store) removeNFTTransfers(blockHash web3.Hash) {
+	transfers := []*store.NFTTransfer{}
+	removed := []*store.NFTTransfer{}
+	for _, t := range s.nftTransfers {
+		if t.BlockHash == blockHash.String() {
+			delete(s.logs, logKey(t.LogPosition))
+			removed = append(removed, t)
+		} else {
+			transfers = append(transfers, t)
+		}
+	}
+	s.nftTransfers = transfers
+
+	// the owner is the destination of the last remaining transfer
+	for _, r := range removed {
+		owner := store.ZeroAddress
+		for _, t := range s.nftTransfers {
+			if t.Addr == r.Addr && t.TokenID == r.TokenID {
+				owner = t.To
+			}
+		}
+		s.setNFTOwner(r.Addr, r.TokenID, owner)
+	}
+}
+
+// GetNFTTransfers returns the ERC721 transfers given a filter
+func (s *Store) GetNFTTransfers(filter store.TransfersFilter) ([]*store.NFTTransfer, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sorter, err := newSorter(filter)
+	if err != nil {
+		return nil, err
+	}
+	key := func(t *store.NFTTransfer) sortKey {
+		return sorter.key(t.LogPosition, 0, t.TokenID)
+	}
+
+	matches := []*store.NFTTransfer{}
+	for _, t := range s.nftTransfers {
+		if match(filter, t.LogPosition, t.Addr, t.From, t.To) && sorter.afterCursor(key(t)) {
+			matches = append(matches, t)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return sorter.less(key(matches[i]), key(matches[j]))
+	})
+
+	low, high := paginate(filter.QueryPagination, len(matches))
+
+	transfers := []*store.NFTTransfer{}
+	for _, t := range matches[low:high] {
+		elem := *t
+		transfers = append(transfers, &elem)
+	}
+	return transfers, nil
+}
+
+// GetNFTOwner returns the current owner of an ERC721 token
+func (s *Store) GetNFTOwner(token web3.Address, tokenID *big.Int) (*store.NFTOwner, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	owner, ok := s.nftOwners[token.String()][tokenID.String()]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	o := &store.NFTOwner{
+		Addr:    token.String(),
+		TokenID: tokenID.String(),
+		Owner:   owner,
+	}
+	return o, nil
+}
@@ -0,0 +1,187 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+)
+
+// CreateWebhook stores a new webhook
+func (s *Store) CreateWebhook(w *store.Webhook) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	elem := *w
+	s.webhooks[w.ID] = &elem
+	return nil
+}
+
+// GetWebhook returns a webhook
+func (s *Store) GetWebhook(id string) (*store.Webhook, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	w, ok := s.webhooks[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	elem := *w
+	return &elem, nil
+}
+
+// ListWebhooks returns all the webhooks sorted by id
+func (s *Store) ListWebhooks() ([]*store.Webhook, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	webhooks := []*store.Webhook{}
+	for _, w := range s.webhooks {
+		elem := *w
+		webhooks = append(webhooks, &elem)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func (s *Store) DeleteWebhook(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.webhooks, id)
+
+	deliveries := []*store.Delivery{}
+	for _, d := range s.deliveries {
+		if d.WebhookID != id {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.deliveries = deliveries
+	return nil
+}
+
+// WriteDeliveries stores new pending deliveries and skips the transfer
+// deliveries already queued
+func (s *Store) WriteDeliveries(deliveries []*store.Delivery) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, d := range deliveries {
+		if d.Type == store.DeliveryTransfer && s.queuedImpl(d.WebhookID, d.BlockHash) {
+			continue
+		}
+		s.deliveryID++
+
+		elem := *d
+		elem.ID = s.deliveryID
+		s.deliveries = append(s.deliveries, &elem)
+	}
+	return nil
+}
+
+// queuedImpl returns true if the webhook has a transfer delivery of the
+// block that was not reversed
+func (s *Store) queuedImpl(webhookID, blockHash string) bool {
+	queued := 0
+	for _, d := range s.deliveries {
+		if d.WebhookID != webhookID || d.BlockHash != blockHash {
+			continue
+		}
+		if d.Type == store.DeliveryTransfer {
+			queued++
+		} else {
+			queued--
+		}
+	}
+	return queued > 0
+}
+
+// PendingDeliveries returns the pending deliveries that are ready
+func (s *Store) PendingDeliveries(head, now uint64, limit int) ([]*store.Delivery, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	deliveries := []*store.Delivery{}
+	for _, d := range s.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if d.Status == store.DeliveryPending && d.ReadyBlock <= head && d.NextAttempt <= now {
+			elem := *d
+			deliveries = append(deliveries, &elem)
+		}
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery updates the state of a delivery
+func (s *Store) UpdateDelivery(d *store.Delivery) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, elem := range s.deliveries {
+		if elem.ID == d.ID {
+			elem.Status = d.Status
+			elem.Attempts = d.Attempts
+			elem.NextAttempt = d.NextAttempt
+			elem.LastError = d.LastError
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+// RevertDeliveries removes the pending transfer deliveries of a block and
+// returns the delivered ones
+func (s *Store) RevertDeliveries(blockHash string) ([]*store.Delivery, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delivered := []*store.Delivery{}
+	deliveries := []*store.Delivery{}
+	for _, d := range s.deliveries {
+		if d.BlockHash != blockHash || d.Type != store.DeliveryTransfer {
+			deliveries = append(deliveries, d)
+			continue
+		}
+		if d.Status == store.DeliveryPending {
+			continue
+		}
+		if d.Status == store.DeliveryDelivered {
+			elem := *d
+			delivered = append(delivered, &elem)
+		}
+		deliveries = append(deliveries, d)
+	}
+	s.deliveries = deliveries
+	return delivered, nil
+}
+
+// ListDeliveries returns the deliveries of a webhook, the most recent first
+func (s *Store) ListDeliveries(webhookID string, p store.QueryPagination) ([]*store.Delivery, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	matches := []*store.Delivery{}
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		if d := s.deliveries[i]; d.WebhookID == webhookID {
+			matches = append(matches, d)
+		}
+	}
+
+	// the deliveries are only paginated by offset
+	p.Cursor = ""
+	low, high := paginate(p, len(matches))
+
+	deliveries := []*store.Delivery{}
+	for _, d := range matches[low:high] {
+		elem := *d
+		deliveries = append(deliveries, &elem)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/umbracle/go-web3"
+)
+
+var metricStoreLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "tokentracker",
+	Subsystem: "store",
+	Name:      "operation_duration_seconds",
+	Help:      "Latency of the writes to the store.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"backend", "operation"})
+
+func init() {
+	prometheus.MustRegister(metricStoreLatency)
+}
+
+// WithMetrics returns a store that measures the latency of the writes of
+// the receipts of a backend
+func WithMetrics(backend string, s Store) Store {
+	return &metricsStore{
+		Store:   s,
+		backend: backend,
+	}
+}
+
+type metricsStore struct {
+	Store
+	backend string
+}
+
+func (m *metricsStore) observe(operation string, start time.Time) {
+	metricStoreLatency.WithLabelValues(m.backend, operation).Observe(time.Since(start).Seconds())
+}
+
+// WriteReceipt implements the store interface
+func (m *metricsStore) WriteReceipt(logs []*web3.Log) ([]*web3.Log, error) {
+	defer m.observe("write_receipt", time.Now())
+	return m.Store.WriteReceipt(logs)
+}
+
+// RemoveReceipts implements the store interface
+func (m *metricsStore) RemoveReceipts(blockHash web3.Hash) error {
+	defer m.observe("remove_receipts", time.Now())
+	return m.Store.RemoveReceipts(blockHash)
+}
+
+// ConfirmTransfers implements the store interface
+func (m *metricsStore) ConfirmTransfers(blockNumber uint64) error {
+	defer m.observe("confirm_transfers", time.Now())
+	return m.Store.ConfirmTransfers(blockNumber)
+}
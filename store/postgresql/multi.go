@@ -0,0 +1,104 @@
+package postgresql
+
+import (
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/jmoiron/sqlx"
+	"github.com/umbracle/go-web3"
+)
+
+func (s *Store) writeMultiTransfersImpl(tx *sqlx.Tx, transfers []*store.MultiTransfer) (bool, error) {
+	inserted := false
+	for _, transfer := range transfers {
+		if err := s.writeTokenImpl(tx, transfer.Addr); err != nil {
+			return false, err
+		}
+
+		query := "INSERT INTO multi_transfers (token_id, nft_id, block_hash, block_number, log_index, batch_index, txn_hash, txn_index, timestamp, operator, from_addr, to_addr, value) VALUES (:token_id, :nft_id, :block_hash, :block_number, :log_index, :batch_index, :txn_hash, :txn_index, :timestamp, :operator, :from_addr, :to_addr, :value) ON CONFLICT (block_hash, log_index, batch_index) DO NOTHING"
+		res, err := tx.NamedExec(query, transfer)
+		if err != nil {
+			return false, err
+		}
+		num, err := res.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		if num == 0 {
+			// the log is already stored
+			continue
+		}
+		inserted = true
+		if err := s.updateMultiBalancesImpl(tx, transfer, false); err != nil {
+			return false, err
+		}
+	}
+	return inserted, nil
+}
+
+func (s *Store) updateMultiBalancesImpl(tx *sqlx.Tx, transfer *store.MultiTransfer, revert bool) error {
+	changes, err := store.MultiBalanceChanges(transfer, revert)
+	if err != nil {
+		return err
+	}
+	for _, b := range changes {
+		query := "INSERT INTO multi_balances (token_id, nft_id, account, value) VALUES ($1, $2, $3, $4) ON CONFLICT (token_id, nft_id, account) DO UPDATE SET value = multi_balances.value + EXCLUDED.value RETURNING value"
+		var value string
+		if err := tx.Get(&value, query, b.Token, b.TokenID, b.Account, b.Value); err != nil {
+			return err
+		}
+		if value == "0" {
+			if _, err := tx.Exec("DELETE FROM multi_balances WHERE token_id=$1 AND nft_id=$2 AND account=$3", b.Token, b.TokenID, b.Account); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) removeMultiTransfersImpl(tx *sqlx.Tx, blockHash web3.Hash) error {
+	transfers := []*store.MultiTransfer{}
+	if err := tx.Select(&transfers, "SELECT token_id, nft_id, from_addr, to_addr, value FROM multi_transfers WHERE block_hash=$1", blockHash.String()); err != nil {
+		return err
+	}
+	for _, transfer := range transfers {
+		if err := s.updateMultiBalancesImpl(tx, transfer, true); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM multi_transfers WHERE block_hash=$1", blockHash.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetMultiTransfers returns the ERC1155 transfers given a filter
+func (s *Store) GetMultiTransfers(filter store.TransfersFilter) ([]*store.MultiTransfer, error) {
+	q := newQueryBuilder("SELECT token_id, nft_id, block_hash, block_number, log_index, batch_index, txn_hash, txn_index, timestamp, confirmed, operator, from_addr, to_addr, value FROM multi_transfers")
+	if err := q.filter(filter, "value", batchOrder); err != nil {
+		return nil, err
+	}
+
+	query, args := q.build()
+
+	transfers := []*store.MultiTransfer{}
+	if err := s.db.Select(&transfers, query, args...); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// GetMultiBalances returns the ERC1155 balances of an account
+func (s *Store) GetMultiBalances(account web3.Address, tokens []web3.Address) ([]*store.MultiBalance, error) {
+	q := newQueryBuilder("SELECT token_id, nft_id, account, value FROM multi_balances")
+	q.whereEq("account", account.String())
+	q.whereAny("token_id", tokens)
+	// the token ids are decimal numbers
+	q.orderBy("token_id", "length(nft_id)", "nft_id")
+
+	query, args := q.build()
+
+	balances := []*store.MultiBalance{}
+	if err := s.db.Select(&balances, query, args...); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
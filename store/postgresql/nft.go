@@ -0,0 +1,98 @@
+package postgresql
+
+import (
+	"math/big"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/jmoiron/sqlx"
+	"github.com/umbracle/go-web3"
+)
+
+func (s *Store) writeNFTTransferImpl(tx *sqlx.Tx, transfer *store.NFTTransfer) (bool, error) {
+	if err := s.writeTokenImpl(tx, transfer.Addr); err != nil {
+		return false, err
+	}
+
+	query := "INSERT INTO nft_transfers (token_id, nft_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, from_addr, to_addr) VALUES (:token_id, :nft_id, :block_hash, :block_number, :log_index, :txn_hash, :txn_index, :timestamp, :from_addr, :to_addr) ON CONFLICT (block_hash, log_index) DO NOTHING"
+	res, err := tx.NamedExec(query, transfer)
+	if err != nil {
+		return false, err
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if num == 0 {
+		// the log is already stored
+		return false, nil
+	}
+	return true, s.setNFTOwnerImpl(tx, transfer.Addr, transfer.TokenID, transfer.To)
+}
+
+func (s *Store) setNFTOwnerImpl(tx *sqlx.Tx, token, tokenID, owner string) error {
+	if owner == store.ZeroAddress {
+		// the token was burnt
+		_, err := tx.Exec("DELETE FROM nft_owners WHERE token_id=$1 AND nft_id=$2", token, tokenID)
+		return err
+	}
+	query := "INSERT INTO nft_owners (token_id, nft_id, owner) VALUES ($1, $2, $3) ON CONFLICT (token_id, nft_id) DO UPDATE SET owner = EXCLUDED.owner"
+	if _, err := tx.Exec(query, token, tokenID, owner); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) removeNFTTransfersImpl(tx *sqlx.Tx, blockHash web3.Hash) error {
+	transfers := []*store.NFTTransfer{}
+	if err := tx.Select(&transfers, "SELECT token_id, nft_id FROM nft_transfers WHERE block_hash=$1", blockHash.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM nft_transfers WHERE block_hash=$1", blockHash.String()); err != nil {
+		return err
+	}
+
+	// the owner is the destination of the last remaining transfer
+	for _, transfer := range transfers {
+		owners := []string{}
+		query := "SELECT to_addr FROM nft_transfers WHERE token_id=$1 AND nft_id=$2 ORDER BY block_number DESC, log_index DESC LIMIT 1"
+		if err := tx.Select(&owners, query, transfer.Addr, transfer.TokenID); err != nil {
+			return err
+		}
+		owner := store.ZeroAddress
+		if len(owners) == 1 {
+			owner = owners[0]
+		}
+		if err := s.setNFTOwnerImpl(tx, transfer.Addr, transfer.TokenID, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNFTTransfers returns the ERC721 transfers given a filter
+func (s *Store) GetNFTTransfers(filter store.TransfersFilter) ([]*store.NFTTransfer, error) {
+	q := newQueryBuilder("SELECT token_id, nft_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr FROM nft_transfers")
+	if err := q.filter(filter, "nft_id", logOrder); err != nil {
+		return nil, err
+	}
+
+	query, args := q.build()
+
+	transfers := []*store.NFTTransfer{}
+	if err := s.db.Select(&transfers, query, args...); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// GetNFTOwner returns the current owner of an ERC721 token
+func (s *Store) GetNFTOwner(token web3.Address, tokenID *big.Int) (*store.NFTOwner, error) {
+	owners := []*store.NFTOwner{}
+	if err := s.db.Select(&owners, "SELECT token_id, nft_id, owner FROM nft_owners WHERE token_id=$1 AND nft_id=$2", token.String(), tokenID.String()); err != nil {
+		return nil, err
+	}
+	if len(owners) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return owners[0], nil
+}
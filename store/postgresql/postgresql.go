@@ -3,14 +3,11 @@ package postgresql
 import (
 	"fmt"
 	"math/big"
-	"strings"
 
 	"github.com/ferranbt/go-eth-token-tracker/store"
 	"github.com/gobuffalo/packr"
 	"github.com/jmoiron/sqlx"
 	"github.com/umbracle/go-web3"
-	"github.com/umbracle/go-web3/abi"
-	"github.com/umbracle/go-web3/contract/builtin/erc20"
 )
 
 const (
@@ -20,7 +17,7 @@ const (
 // Factory is the factory method for the Postgresql store
 func Factory(config map[string]interface{}) (store.Store, error) {
 	endpoint := defaultEndpoint
-	
+
 	endpointRaw, ok := config["endpoint"]
 	if ok {
 		endpoint, ok = endpointRaw.(string)
@@ -31,10 +28,6 @@ func Factory(config map[string]interface{}) (store.Store, error) {
 	return New(endpoint)
 }
 
-var (
-	transferEvent = erc20.ERC20Abi().Events["Transfer"]
-)
-
 var (
 	ddl = packr.NewBox("./db")
 )
@@ -52,33 +45,45 @@ func New(endpoint string) (*Store, error) {
 	}
 	s := &Store{db}
 	if err := s.setupDB(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return s, nil
 }
 
+// setupDB applies the schema. The schema is idempotent and it upgrades the
+// tables created by older versions, so it runs on every start. The upgrade
+// is rolled back if there are transfers that cannot be upgraded.
 func (s *Store) setupDB() error {
-	var count int
-	if err := s.db.Get(&count, "SELECT count(*) FROM information_schema.tables WHERE table_schema='public'"); err != nil {
+	tx, err := s.db.Beginx()
+	if err != nil {
 		return err
 	}
-	if count != 0 {
-		return nil
+	if _, err := tx.Exec(ddl.String("./schema/schema.sql")); err != nil {
+		tx.Rollback()
+		return err
 	}
-	if _, err := s.db.Exec(ddl.String("./schema/schema.sql")); err != nil {
+	var legacy int
+	if err := tx.Get(&legacy, "SELECT count(*) FROM transfers WHERE block_number IS NULL"); err != nil {
+		tx.Rollback()
 		return err
 	}
-	return nil
+	if legacy != 0 {
+		tx.Rollback()
+		return store.ErrLegacyTransfers
+	}
+	return tx.Commit()
 }
 
-// WriteReceipt writes a new receipt
-func (s *Store) WriteReceipt(logs []*web3.Log) error {
+// WriteBlocks writes the blocks of the receipts
+func (s *Store) WriteBlocks(blocks []*store.Block) error {
 	tx, err := s.db.Beginx()
 	if err != nil {
 		return err
 	}
-	for _, log := range logs {
-		if err := s.writeLogImpl(tx, log); err != nil {
+	for _, b := range blocks {
+		if _, err := tx.NamedExec("INSERT INTO blocks (hash, number, timestamp) VALUES (:hash, :number, :timestamp) ON CONFLICT (hash) DO NOTHING", b); err != nil {
+			tx.Rollback()
 			return err
 		}
 	}
@@ -88,49 +93,165 @@ func (s *Store) WriteReceipt(logs []*web3.Log) error {
 	return nil
 }
 
-func (s *Store) writeLogImpl(tx *sqlx.Tx, log *web3.Log) error {
-	if len(log.Topics) != 3 {
-		// non-standard erc20 token
-		return nil
+// blockTimestampImpl returns the time of the block or zero if the block is
+// not stored
+func (s *Store) blockTimestampImpl(tx *sqlx.Tx, blockHash web3.Hash) (uint64, error) {
+	timestamps := []uint64{}
+	if err := tx.Select(&timestamps, "SELECT timestamp FROM blocks WHERE hash=$1", blockHash.String()); err != nil {
+		return 0, err
+	}
+	if len(timestamps) == 0 {
+		return 0, nil
 	}
-	vals, err := abi.ParseLog(transferEvent.Inputs, log)
+	return timestamps[0], nil
+}
+
+// WriteReceipt writes a new receipt and returns the logs written. Logs that
+// are already stored are ignored
+func (s *Store) WriteReceipt(logs []*web3.Log) ([]*web3.Log, error) {
+	tx, err := s.db.Beginx()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	written := []*web3.Log{}
+	timestamps := map[web3.Hash]uint64{}
+	for _, log := range logs {
+		timestamp, ok := timestamps[log.BlockHash]
+		if !ok {
+			if timestamp, err = s.blockTimestampImpl(tx, log.BlockHash); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			timestamps[log.BlockHash] = timestamp
+		}
+		inserted, err := s.writeLogImpl(tx, log, timestamp)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if inserted {
+			written = append(written, log)
+		}
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
 
-	token := log.Address
-	if err := s.writeTokenImpl(tx, token); err != nil {
-		return err
+// writeLogImpl writes the transfers of a log. It returns false if the log
+// is already stored or it is not a token transfer.
+func (s *Store) writeLogImpl(tx *sqlx.Tx, log *web3.Log, timestamp uint64) (bool, error) {
+	transfer, err := store.ParseTransfer(log)
+	if err != nil {
+		return false, err
+	}
+	if transfer != nil {
+		transfer.Timestamp = timestamp
+		return s.writeTransferImpl(tx, transfer)
+	}
+	nftTransfer, err := store.ParseNFTTransfer(log)
+	if err != nil {
+		return false, err
+	}
+	if nftTransfer != nil {
+		nftTransfer.Timestamp = timestamp
+		return s.writeNFTTransferImpl(tx, nftTransfer)
+	}
+	multiTransfers, err := store.ParseMultiTransfers(log)
+	if err != nil {
+		return false, err
+	}
+	if multiTransfers != nil {
+		for _, t := range multiTransfers {
+			t.Timestamp = timestamp
+		}
+		return s.writeMultiTransfersImpl(tx, multiTransfers)
+	}
+	// non-standard token
+	return false, nil
+}
+
+func (s *Store) writeTransferImpl(tx *sqlx.Tx, transfer *store.Transfer) (bool, error) {
+	if err := s.writeTokenImpl(tx, transfer.Addr); err != nil {
+		return false, err
 	}
 
-	from, err := decodeAddress(vals, "from")
+	query := "INSERT INTO transfers (token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, from_addr, to_addr, value) VALUES (:token_id, :block_hash, :block_number, :log_index, :txn_hash, :txn_index, :timestamp, :from_addr, :to_addr, :value) ON CONFLICT (block_hash, log_index) DO NOTHING"
+	res, err := tx.NamedExec(query, transfer)
 	if err != nil {
-		return err
+		return false, err
 	}
-	to, err := decodeAddress(vals, "to")
+	num, err := res.RowsAffected()
 	if err != nil {
-		return err
+		return false, err
 	}
-	value, err := decodeBigInt(vals, "value")
+	if num == 0 {
+		// the log is already stored
+		return false, nil
+	}
+	return true, s.updateBalancesImpl(tx, transfer, false)
+}
+
+func (s *Store) updateBalancesImpl(tx *sqlx.Tx, transfer *store.Transfer, revert bool) error {
+	changes, err := store.BalanceChanges(transfer, revert)
 	if err != nil {
 		return err
 	}
-	query := "INSERT INTO transfers (token_id, block_hash, txn_hash, from_addr, to_addr, value) VALUES ($1, $2, $3, $4, $5, $6)"
-	if _, err := tx.Exec(query, token.String(), log.BlockHash.String(), log.TransactionHash.String(), from.String(), to.String(), value.String()); err != nil {
+	for _, b := range changes {
+		query := "INSERT INTO balances (token_id, account, value) VALUES ($1, $2, $3) ON CONFLICT (token_id, account) DO UPDATE SET value = balances.value + EXCLUDED.value RETURNING value"
+		var value string
+		if err := tx.Get(&value, query, b.Token, b.Account, b.Value); err != nil {
+			return err
+		}
+		if value == "0" {
+			if _, err := tx.Exec("DELETE FROM balances WHERE token_id=$1 AND account=$2", b.Token, b.Account); err != nil {
+				return err
+			}
+		}
+		if revert {
+			// the checkpoints of the reverted blocks are removed afterwards
+			continue
+		}
+		if err := s.writeCheckpointImpl(tx, b.Token, b.Account, transfer.BlockNumber, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type checkpoint struct {
+	BlockNumber uint64 `db:"block_number"`
+	Value       string `db:"value"`
+}
+
+// writeCheckpointImpl updates the checkpoint of the balance at the block if
+// it exists or if the last one is more than CheckpointInterval blocks away.
+// It expects the receipts to be written in block order.
+func (s *Store) writeCheckpointImpl(tx *sqlx.Tx, token, account string, blockNumber uint64, value string) error {
+	last := []uint64{}
+	if err := tx.Select(&last, "SELECT block_number FROM balance_checkpoints WHERE token_id=$1 AND account=$2 ORDER BY block_number DESC LIMIT 1", token, account); err != nil {
+		return err
+	}
+	if len(last) == 1 && last[0] != blockNumber && blockNumber < last[0]+store.CheckpointInterval {
+		return nil
+	}
+	query := "INSERT INTO balance_checkpoints (token_id, account, block_number, value) VALUES ($1, $2, $3, $4) ON CONFLICT (token_id, account, block_number) DO UPDATE SET value = EXCLUDED.value"
+	if _, err := tx.Exec(query, token, account, blockNumber, value); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *Store) writeTokenImpl(tx *sqlx.Tx, token web3.Address) error {
+func (s *Store) writeTokenImpl(tx *sqlx.Tx, token string) error {
 	var count int
-	if err := tx.Get(&count, "SELECT count(*) FROM tokens WHERE id=$1", token.String()); err != nil {
+	if err := tx.Get(&count, "SELECT count(*) FROM tokens WHERE id=$1", token); err != nil {
 		return err
 	}
 	if count != 0 {
 		return nil
 	}
-	if _, err := tx.Exec("INSERT INTO tokens (id) VALUES ($1)", token.String()); err != nil {
+	if _, err := tx.Exec("INSERT INTO tokens (id) VALUES ($1)", token); err != nil {
 		return err
 	}
 	return nil
@@ -141,6 +262,11 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// Ping checks that the store can be reached
+func (s *Store) Ping() error {
+	return s.db.Ping()
+}
+
 // RemoveReceipts removes the receipts by block hash
 func (s *Store) RemoveReceipts(blockHash web3.Hash) error {
 	tx, err := s.db.Beginx()
@@ -148,8 +274,8 @@ func (s *Store) RemoveReceipts(blockHash web3.Hash) error {
 		return err
 	}
 
-	query := "DELETE FROM transfers WHERE block_hash=$1"
-	if _, err := tx.Exec(query, blockHash.String()); err != nil {
+	if err := s.removeReceiptsImpl(tx, blockHash); err != nil {
+		tx.Rollback()
 		return err
 	}
 	if err := tx.Commit(); err != nil {
@@ -158,76 +284,178 @@ func (s *Store) RemoveReceipts(blockHash web3.Hash) error {
 	return nil
 }
 
-func decodeAddress(vals map[string]interface{}, attr string) (web3.Address, error) {
-	val, ok := vals[attr]
-	if !ok {
-		return web3.Address{}, fmt.Errorf("key '%s' not found", attr)
+func (s *Store) removeReceiptsImpl(tx *sqlx.Tx, blockHash web3.Hash) error {
+	transfers := []*store.Transfer{}
+	if err := tx.Select(&transfers, "SELECT token_id, block_number, from_addr, to_addr, value FROM transfers WHERE block_hash=$1", blockHash.String()); err != nil {
+		return err
 	}
-	valStr, ok := val.(web3.Address)
-	if !ok {
-		fmt.Println(val)
-		return web3.Address{}, fmt.Errorf("cannot convert '%s' to string", attr)
+	for _, transfer := range transfers {
+		if err := s.updateBalancesImpl(tx, transfer, true); err != nil {
+			return err
+		}
+	}
+	if len(transfers) != 0 {
+		// checkpoints are only a cache, it is safe to remove any of them
+		if _, err := tx.Exec("DELETE FROM balance_checkpoints WHERE block_number >= $1", transfers[0].BlockNumber); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM transfers WHERE block_hash=$1", blockHash.String()); err != nil {
+		return err
+	}
+	if err := s.removeNFTTransfersImpl(tx, blockHash); err != nil {
+		return err
+	}
+	if err := s.removeMultiTransfersImpl(tx, blockHash); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM blocks WHERE hash=$1", blockHash.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ConfirmTransfers marks as confirmed the transfers up to the block
+func (s *Store) ConfirmTransfers(blockNumber uint64) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := s.confirmTransfersImpl(tx, blockNumber); err != nil {
+		tx.Rollback()
+		return err
 	}
-	return valStr, nil
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
 }
 
-func decodeBigInt(vals map[string]interface{}, attr string) (*big.Int, error) {
-	val, ok := vals[attr]
-	if !ok {
-		return nil, fmt.Errorf("key '%s' not found", attr)
+func (s *Store) confirmTransfersImpl(tx *sqlx.Tx, blockNumber uint64) error {
+	if _, err := tx.Exec("UPDATE transfers SET confirmed = TRUE WHERE NOT confirmed AND block_number <= $1", blockNumber); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE nft_transfers SET confirmed = TRUE WHERE NOT confirmed AND block_number <= $1", blockNumber); err != nil {
+		return err
 	}
-	valStr, ok := val.(*big.Int)
-	if !ok {
-		return nil, fmt.Errorf("cannot convert '%s' to big.Int", attr)
+	if _, err := tx.Exec("UPDATE multi_transfers SET confirmed = TRUE WHERE NOT confirmed AND block_number <= $1", blockNumber); err != nil {
+		return err
 	}
-	return valStr, nil
+	return nil
 }
 
 // ListTokens returns the list of registered tokens
-func (s *Store) ListTokens(p store.QueryPagination) ([]string, error) {
-	var tokens []string
-	query := "SELECT id FROM tokens" + p.String()
-	if err := s.db.Select(&tokens, query); err != nil {
+func (s *Store) ListTokens(p store.QueryPagination) ([]*store.Token, error) {
+	q := newQueryBuilder("SELECT id, name, symbol, decimals, total_supply, resolved FROM tokens")
+	if p.Cursor != "" {
+		token, err := store.DecodeTokenCursor(p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q.whereAfter([]string{"id"}, token)
+	}
+	q.orderBy("id")
+	q.paginate(p)
+
+	query, args := q.build()
+
+	tokens := []*store.Token{}
+	if err := s.db.Select(&tokens, query, args...); err != nil {
 		return nil, err
 	}
 	return tokens, nil
 }
 
-func sliceAddressToString(w []web3.Address) []string {
-	resp := []string{}
-	for _, i := range w {
-		resp = append(resp, i.String())
+// GetToken returns a token and its metadata
+func (s *Store) GetToken(token web3.Address) (*store.Token, error) {
+	tokens := []*store.Token{}
+	if err := s.db.Select(&tokens, "SELECT id, name, symbol, decimals, total_supply, resolved FROM tokens WHERE id=$1", token.String()); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, store.ErrNotFound
 	}
-	return resp
+	return tokens[0], nil
+}
+
+// WriteTokenMetadata updates the metadata of a token
+func (s *Store) WriteTokenMetadata(token *store.Token) error {
+	query := "UPDATE tokens SET name=:name, symbol=:symbol, decimals=:decimals, total_supply=:total_supply, resolved=:resolved WHERE id=:id"
+	if _, err := s.db.NamedExec(query, token); err != nil {
+		return err
+	}
+	return nil
+}
+
+func transfersQuery(filter store.TransfersFilter) (string, []interface{}, error) {
+	q := newQueryBuilder("SELECT token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr, value FROM transfers")
+	if err := q.filter(filter, "value", logOrder); err != nil {
+		return "", nil, err
+	}
+	query, args := q.build()
+	return query, args, nil
 }
 
 // GetTokenTransfers returns the transfers given a filter
 func (s *Store) GetTokenTransfers(filter store.TransfersFilter) ([]*store.Transfer, error) {
-	query := "SELECT token_id, from_addr, to_addr, value FROM transfers"
-
-	whereAttr := []string{}
-	// filter by from
-	if len(filter.From) != 0 {
-		whereAttr = append(whereAttr, "from_addr IN ('"+strings.Join(sliceAddressToString(filter.From), "', '")+"')")
+	query, args, err := transfersQuery(filter)
+	if err != nil {
+		return nil, err
 	}
-	// filter by to
-	if len(filter.To) != 0 {
-		whereAttr = append(whereAttr, "to_addr IN ('"+strings.Join(sliceAddressToString(filter.To), "', '")+"')")
+
+	transfers := []*store.Transfer{}
+	if err := s.db.Select(&transfers, query, args...); err != nil {
+		return nil, err
 	}
-	// filter by tokens
-	if len(filter.Tokens) != 0 {
-		whereAttr = append(whereAttr, "token_id IN ('"+strings.Join(sliceAddressToString(filter.Tokens), "', '")+"')")
+	return transfers, nil
+}
+
+// GetBalances returns the balances of an account
+func (s *Store) GetBalances(account web3.Address, tokens []web3.Address) ([]*store.Balance, error) {
+	q := newQueryBuilder("SELECT token_id, account, value FROM balances")
+	q.whereEq("account", account.String())
+	q.whereAny("token_id", tokens)
+	q.orderBy("token_id")
+
+	query, args := q.build()
+
+	balances := []*store.Balance{}
+	if err := s.db.Select(&balances, query, args...); err != nil {
+		return nil, err
 	}
-	if len(whereAttr) != 0 {
-		query += " WHERE " + strings.Join(whereAttr, " AND ")
+	return balances, nil
+}
+
+// GetBalanceAt returns the balance of an account after a given block
+func (s *Store) GetBalanceAt(token, account web3.Address, blockNumber uint64) (*store.Balance, error) {
+	checkpoints := []*checkpoint{}
+	query := "SELECT block_number, value FROM balance_checkpoints WHERE token_id=$1 AND account=$2 AND block_number <= $3 ORDER BY block_number DESC LIMIT 1"
+	if err := s.db.Select(&checkpoints, query, token.String(), account.String(), blockNumber); err != nil {
+		return nil, err
 	}
 
-	// add the pagination
-	query += filter.QueryPagination.String()
+	balance := big.NewInt(0)
+	from := uint64(0)
+	if len(checkpoints) == 1 {
+		if _, ok := balance.SetString(checkpoints[0].Value, 10); !ok {
+			return nil, fmt.Errorf("cannot convert value '%s' to big.Int", checkpoints[0].Value)
+		}
+		from = checkpoints[0].BlockNumber + 1
+	}
 
 	transfers := []*store.Transfer{}
-	if err := s.db.Select(&transfers, query); err != nil {
+	query = "SELECT token_id, from_addr, to_addr, value FROM transfers WHERE token_id=$1 AND (from_addr=$2 OR to_addr=$2) AND block_number >= $3 AND block_number <= $4"
+	if err := s.db.Select(&transfers, query, token.String(), account.String(), from, blockNumber); err != nil {
 		return nil, err
 	}
-	return transfers, nil
+	if err := store.ApplyTransfers(account.String(), balance, transfers); err != nil {
+		return nil, err
+	}
+
+	b := &store.Balance{
+		Token:   token.String(),
+		Account: account.String(),
+		Value:   balance.String(),
+	}
+	return b, nil
 }
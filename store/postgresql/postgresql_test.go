@@ -41,3 +41,145 @@ func testPostgreSQL(t *testing.T) (store.Store, func()) {
 func TestStore(t *testing.T) {
 	store.TestStore(t, testPostgreSQL)
 }
+
+// baselineSchema is the schema of the first version of the store
+var baselineSchema = `
+CREATE TABLE tokens (
+    id TEXT PRIMARY KEY
+);
+
+CREATE TABLE transfers (
+    token_id        TEXT REFERENCES tokens(id),
+    block_hash      TEXT,
+    txn_hash        TEXT,
+    from_addr       TEXT,
+    to_addr         TEXT,
+    value           TEXT
+);
+`
+
+func TestUpgradeSchema(t *testing.T) {
+	db, err := sqlx.Connect("postgres", "user=postgres dbname=postgres sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := db.Exec(truncateExec); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if _, err := db.Exec(truncateExec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	// the schema upgrades the tables and it can be applied again
+	s := &Store{db}
+	for i := 0; i < 2; i++ {
+		if err := s.setupDB(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.TestStore(t, func(t *testing.T) (store.Store, func()) {
+		return s, func() {
+			// empty the tables but keep the upgraded schema
+			if _, err := db.Exec("TRUNCATE tokens, blocks, transfers, balances, balance_checkpoints, nft_transfers, nft_owners, multi_transfers, multi_balances, webhooks, webhook_deliveries"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+// positionSchema is the schema of the version that recorded the position
+// of the transfers in the chain
+var positionSchema = `
+CREATE TABLE tokens (
+    id TEXT PRIMARY KEY
+);
+
+CREATE TABLE transfers (
+    token_id        TEXT REFERENCES tokens(id),
+    block_hash      TEXT,
+    block_number    BIGINT,
+    log_index       BIGINT,
+    txn_hash        TEXT,
+    txn_index       BIGINT,
+    from_addr       TEXT,
+    to_addr         TEXT,
+    value           TEXT
+);
+`
+
+// testOlderDB creates the tables of an older version and runs the
+// statements on them
+func testOlderDB(t *testing.T, schema string, stmts ...string) (*sqlx.DB, func()) {
+	db, err := sqlx.Connect("postgres", "user=postgres dbname=postgres sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close := func() {
+		if _, err := db.Exec(truncateExec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close()
+	for _, stmt := range append([]string{schema}, stmts...) {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, close
+}
+
+func TestUpgradeTransfers(t *testing.T) {
+	db, close := testOlderDB(t, positionSchema,
+		"INSERT INTO tokens (id) VALUES ('0x1')",
+		"INSERT INTO transfers (token_id, block_hash, block_number, log_index, txn_hash, txn_index, from_addr, to_addr, value) VALUES ('0x1', '0xa', 1, 0, '0xb', 0, '0x2', '0x3', '1')",
+	)
+	defer close()
+
+	s := &Store{db}
+	if err := s.setupDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transfers are listed and confirmed with an unknown timestamp
+	if err := s.ConfirmTransfers(1); err != nil {
+		t.Fatal(err)
+	}
+	transfers, err := s.GetTokenTransfers(store.TransfersFilter{Status: store.StatusConfirmed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 {
+		t.Fatalf("expected 1 transfer but found %d", len(transfers))
+	}
+	if transfer := transfers[0]; transfer.BlockNumber != 1 || transfer.Timestamp != 0 || transfer.Value != "1" {
+		t.Fatal("bad transfer")
+	}
+}
+
+func TestUpgradeLegacyTransfers(t *testing.T) {
+	db, close := testOlderDB(t, baselineSchema,
+		"INSERT INTO tokens (id) VALUES ('0x1')",
+		"INSERT INTO transfers (token_id, block_hash, txn_hash, from_addr, to_addr, value) VALUES ('0x1', '0xa', '0xb', '0x2', '0x3', '1')",
+	)
+	defer close()
+
+	// the transfers without a position cannot be upgraded
+	s := &Store{db}
+	if err := s.setupDB(); err != store.ErrLegacyTransfers {
+		t.Fatalf("expected legacy transfers but found %v", err)
+	}
+
+	// and the tables are not changed
+	var tables int
+	if err := db.Get(&tables, "SELECT count(*) FROM pg_tables WHERE schemaname = current_schema()"); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 2 {
+		t.Fatal("the tables are upgraded")
+	}
+}
@@ -0,0 +1,181 @@
+package postgresql
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/lib/pq"
+	"github.com/umbracle/go-web3"
+)
+
+// queryBuilder builds a sql query binding every value as a parameter so that
+// the text of the query only depends on which filters are set.
+type queryBuilder struct {
+	query  string
+	where  []string
+	suffix string
+	args   []interface{}
+}
+
+func newQueryBuilder(query string) *queryBuilder {
+	return &queryBuilder{
+		query: query,
+		where: []string{},
+		args:  []interface{}{},
+	}
+}
+
+// bind adds a new argument and returns its placeholder
+func (q *queryBuilder) bind(val interface{}) string {
+	q.args = append(q.args, val)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// whereEq filters the column by a value
+func (q *queryBuilder) whereEq(column string, val interface{}) {
+	q.where = append(q.where, column+" = "+q.bind(val))
+}
+
+// whereAny filters the column by any of the addresses
+func (q *queryBuilder) whereAny(column string, addrs []web3.Address) {
+	if len(addrs) == 0 {
+		return
+	}
+	q.where = append(q.where, column+" = ANY("+q.bind(pq.Array(sliceAddressToString(addrs)))+")")
+}
+
+// whereRange filters the column by an inclusive range. A zero bound is not
+// applied.
+func (q *queryBuilder) whereRange(column string, low, high uint64) {
+	if low != 0 {
+		q.where = append(q.where, column+" >= "+q.bind(low))
+	}
+	if high != 0 {
+		q.where = append(q.where, column+" <= "+q.bind(high))
+	}
+}
+
+// whereAfter filters the rows that come after the values in the order of
+// the columns
+func (q *queryBuilder) whereAfter(columns []string, vals ...interface{}) {
+	q.whereCompare(">", columns, vals)
+}
+
+// whereBefore filters the rows that come before the values in the order of
+// the columns
+func (q *queryBuilder) whereBefore(columns []string, vals ...interface{}) {
+	q.whereCompare("<", columns, vals)
+}
+
+func (q *queryBuilder) whereCompare(op string, columns []string, vals []interface{}) {
+	binds := []string{}
+	for _, val := range vals {
+		binds = append(binds, q.bind(val))
+	}
+	if len(columns) == 1 {
+		q.where = append(q.where, columns[0]+" "+op+" "+binds[0])
+		return
+	}
+	q.where = append(q.where, "("+strings.Join(columns, ", ")+") "+op+" ("+strings.Join(binds, ", ")+")")
+}
+
+// orderBy sets the order of the results
+func (q *queryBuilder) orderBy(columns ...string) {
+	q.suffix += " ORDER BY " + strings.Join(columns, ", ")
+}
+
+// descending returns the columns in descending order
+func descending(columns []string) []string {
+	res := []string{}
+	for _, column := range columns {
+		res = append(res, column+" DESC")
+	}
+	return res
+}
+
+// paginate adds the limit and offset of the query. The offset is not used
+// with a cursor.
+func (q *queryBuilder) paginate(p store.QueryPagination) {
+	if p.Limit == 0 {
+		return
+	}
+	q.suffix += " LIMIT " + q.bind(p.Limit)
+	if p.Cursor == "" {
+		q.suffix += " OFFSET " + q.bind(p.Offset)
+	}
+}
+
+var (
+	// logOrder are the columns that sort the transfers in chain order
+	logOrder = []string{"block_number", "log_index"}
+
+	// batchOrder are the columns that sort the erc1155 transfers in
+	// chain order
+	batchOrder = []string{"block_number", "log_index", "batch_index"}
+)
+
+// filter adds the conditions, the order and the pagination of a transfers
+// filter. The transfers are sorted by the order columns or by the value
+// column first if they are sorted by value.
+func (q *queryBuilder) filter(filter store.TransfersFilter, value string, order []string) error {
+	q.whereAny("from_addr", filter.From)
+	q.whereAny("to_addr", filter.To)
+	q.whereAny("token_id", filter.Tokens)
+	q.whereRange("block_number", filter.FromBlock, filter.ToBlock)
+	q.whereRange("timestamp", filter.FromTime, filter.ToTime)
+	if filter.FromTime == 0 && filter.ToTime != 0 {
+		// the transfers with an unknown timestamp are not in the range
+		q.where = append(q.where, "timestamp <> 0")
+	}
+	switch filter.Status {
+	case store.StatusConfirmed:
+		q.where = append(q.where, "confirmed")
+	case store.StatusPending:
+		q.where = append(q.where, "NOT confirmed")
+	}
+
+	cursor, err := store.DecodeTransfersCursor(filter)
+	if err != nil {
+		return err
+	}
+	columns := order
+	if filter.Sort == store.SortValue {
+		// the values are decimal numbers
+		columns = append([]string{"length(" + value + ")", value}, order...)
+	}
+	if cursor != nil {
+		vals := []interface{}{cursor.BlockNumber, cursor.LogIndex, cursor.BatchIndex}[:len(order)]
+		if filter.Sort == store.SortValue {
+			vals = append([]interface{}{len(cursor.Value), cursor.Value}, vals...)
+		}
+		if filter.Desc {
+			q.whereBefore(columns, vals...)
+		} else {
+			q.whereAfter(columns, vals...)
+		}
+	}
+	if filter.Desc {
+		columns = descending(columns)
+	}
+	q.orderBy(columns...)
+	q.paginate(filter.QueryPagination)
+	return nil
+}
+
+// build returns the query and the arguments to bind
+func (q *queryBuilder) build() (string, []interface{}) {
+	query := q.query
+	if len(q.where) != 0 {
+		query += " WHERE " + strings.Join(q.where, " AND ")
+	}
+	return query + q.suffix, q.args
+}
+
+func sliceAddressToString(w []web3.Address) []string {
+	resp := []string{}
+	for _, i := range w {
+		resp = append(resp, i.String())
+	}
+	return resp
+}
@@ -0,0 +1,234 @@
+package postgresql
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+func randomAddresses(r *rand.Rand) []web3.Address {
+	num := r.Intn(3)
+	if num == 0 {
+		return nil
+	}
+	addrs := make([]web3.Address, num)
+	for i := range addrs {
+		r.Read(addrs[i][:])
+	}
+	return addrs
+}
+
+func randomBound(r *rand.Rand) uint64 {
+	if r.Intn(2) == 0 {
+		return 0
+	}
+	return uint64(r.Int63())
+}
+
+func randomFilter(r *rand.Rand) store.TransfersFilter {
+	filter := store.TransfersFilter{
+		From:   randomAddresses(r),
+		To:     randomAddresses(r),
+		Tokens: randomAddresses(r),
+
+		FromBlock: randomBound(r),
+		ToBlock:   randomBound(r),
+		FromTime:  randomBound(r),
+		ToTime:    randomBound(r),
+	}
+	if r.Intn(2) == 0 {
+		filter.Limit = r.Int() - r.Int()
+		filter.Offset = r.Int() - r.Int()
+	}
+	if r.Intn(2) == 0 {
+		cursor := &store.LogCursor{
+			BlockNumber: uint64(r.Int63()),
+			LogIndex:    uint64(r.Int63()),
+		}
+		filter.Cursor = cursor.Encode()
+	}
+	return filter
+}
+
+// shapeFilter returns a filter with the same filters set as 'filter' but
+// with fixed values.
+func shapeFilter(filter store.TransfersFilter) store.TransfersFilter {
+	shape := func(addrs []web3.Address) []web3.Address {
+		if len(addrs) == 0 {
+			return nil
+		}
+		return []web3.Address{{}}
+	}
+	bound := func(val uint64) uint64 {
+		if val == 0 {
+			return 0
+		}
+		return 1
+	}
+	res := store.TransfersFilter{
+		From:   shape(filter.From),
+		To:     shape(filter.To),
+		Tokens: shape(filter.Tokens),
+
+		FromBlock: bound(filter.FromBlock),
+		ToBlock:   bound(filter.ToBlock),
+		FromTime:  bound(filter.FromTime),
+		ToTime:    bound(filter.ToTime),
+	}
+	if filter.Limit != 0 {
+		res.Limit = 1
+	}
+	if filter.Cursor != "" {
+		res.Cursor = (&store.LogCursor{}).Encode()
+	}
+	return res
+}
+
+func TestTransfersQueryShape(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	for i := 0; i < 1000; i++ {
+		filter := randomFilter(r)
+
+		query, args, err := transfersQuery(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, expectedArgs, err := transfersQuery(shapeFilter(filter))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if query != expected {
+			t.Fatalf("bad query shape: %s", query)
+		}
+		if len(args) != len(expectedArgs) {
+			t.Fatalf("expected %d args but found %d", len(expectedArgs), len(args))
+		}
+		for _, addrs := range [][]web3.Address{filter.From, filter.To, filter.Tokens} {
+			for _, addr := range addrs {
+				if strings.Contains(query, addr.String()[2:]) {
+					t.Fatalf("value %s found in the query", addr.String())
+				}
+			}
+		}
+	}
+}
+
+func TestTransfersQuery(t *testing.T) {
+	query, args, err := transfersQuery(store.TransfersFilter{
+		QueryPagination: store.QueryPagination{
+			Limit:  10,
+			Offset: 5,
+		},
+		From:     []web3.Address{{0x1}},
+		Tokens:   []web3.Address{{0x2}, {0x3}},
+		FromTime: 100,
+		ToTime:   200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "SELECT token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr, value FROM transfers WHERE from_addr = ANY($1) AND token_id = ANY($2) AND timestamp >= $3 AND timestamp <= $4 ORDER BY block_number, log_index LIMIT $5 OFFSET $6"
+	if query != expected {
+		t.Fatalf("bad query: %s", query)
+	}
+	if len(args) != 6 {
+		t.Fatal("6 args expected")
+	}
+}
+
+func TestTransfersQueryCursor(t *testing.T) {
+	cursor := &store.LogCursor{BlockNumber: 10, LogIndex: 2}
+	query, args, err := transfersQuery(store.TransfersFilter{
+		QueryPagination: store.QueryPagination{
+			Limit:  10,
+			Offset: 5,
+			Cursor: cursor.Encode(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the offset is not used with a cursor
+	expected := "SELECT token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr, value FROM transfers WHERE (block_number, log_index) > ($1, $2) ORDER BY block_number, log_index LIMIT $3"
+	if query != expected {
+		t.Fatalf("bad query: %s", query)
+	}
+	if len(args) != 3 {
+		t.Fatal("3 args expected")
+	}
+
+	if _, _, err := transfersQuery(store.TransfersFilter{QueryPagination: store.QueryPagination{Cursor: "bad"}}); err != store.ErrInvalidCursor {
+		t.Fatal("invalid cursor expected")
+	}
+}
+
+func TestTransfersQuerySort(t *testing.T) {
+	cursor := &store.LogCursor{BlockNumber: 10, LogIndex: 2, Value: "100"}
+	query, args, err := transfersQuery(store.TransfersFilter{
+		QueryPagination: store.QueryPagination{
+			Limit:  10,
+			Cursor: cursor.Encode(),
+		},
+		Sort: store.SortValue,
+		Desc: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "SELECT token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr, value FROM transfers WHERE (length(value), value, block_number, log_index) < ($1, $2, $3, $4) ORDER BY length(value) DESC, value DESC, block_number DESC, log_index DESC LIMIT $5"
+	if query != expected {
+		t.Fatalf("bad query: %s", query)
+	}
+	if len(args) != 5 {
+		t.Fatal("5 args expected")
+	}
+
+	// the cursor is only valid for the sort of the query
+	if _, _, err := transfersQuery(store.TransfersFilter{QueryPagination: store.QueryPagination{Cursor: cursor.Encode()}}); err != store.ErrInvalidCursor {
+		t.Fatal("invalid cursor expected")
+	}
+}
+
+func TestTransfersQueryStatus(t *testing.T) {
+	query, args, err := transfersQuery(store.TransfersFilter{
+		From:   []web3.Address{{0x1}},
+		Status: store.StatusPending,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "SELECT token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr, value FROM transfers WHERE from_addr = ANY($1) AND NOT confirmed ORDER BY block_number, log_index"
+	if query != expected {
+		t.Fatalf("bad query: %s", query)
+	}
+	if len(args) != 1 {
+		t.Fatal("1 arg expected")
+	}
+}
+
+func TestTransfersQueryUntil(t *testing.T) {
+	query, args, err := transfersQuery(store.TransfersFilter{
+		ToTime: 200,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transfers with an unknown timestamp are not in the range
+	expected := "SELECT token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr, value FROM transfers WHERE timestamp <= $1 AND timestamp <> 0 ORDER BY block_number, log_index"
+	if query != expected {
+		t.Fatalf("bad query: %s", query)
+	}
+	if len(args) != 1 {
+		t.Fatal("1 arg expected")
+	}
+}
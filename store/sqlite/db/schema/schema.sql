@@ -0,0 +1,143 @@
+-- The schema is applied on every start. The tables of older versions are
+-- upgraded by the migrations before it is applied.
+
+CREATE TABLE IF NOT EXISTS tokens (
+    id              TEXT PRIMARY KEY,
+    name            TEXT NOT NULL DEFAULT '',
+    symbol          TEXT NOT NULL DEFAULT '',
+    decimals        INTEGER,
+    total_supply    TEXT NOT NULL DEFAULT '',
+    resolved        BOOLEAN NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS blocks (
+    hash            TEXT PRIMARY KEY,
+    number          BIGINT,
+    timestamp       BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS transfers (
+    token_id        TEXT REFERENCES tokens(id),
+    block_hash      TEXT,
+    block_number    BIGINT,
+    log_index       BIGINT,
+    txn_hash        TEXT,
+    txn_index       BIGINT,
+    timestamp       BIGINT,
+    from_addr       TEXT,
+    to_addr         TEXT,
+    value           TEXT,
+    confirmed       BOOLEAN NOT NULL DEFAULT 0,
+    UNIQUE (block_hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS transfers_from_idx ON transfers (from_addr, block_number);
+CREATE INDEX IF NOT EXISTS transfers_to_idx ON transfers (to_addr, block_number);
+CREATE INDEX IF NOT EXISTS transfers_block_idx ON transfers (block_number, log_index);
+CREATE INDEX IF NOT EXISTS transfers_pending_idx ON transfers (block_number) WHERE confirmed = 0;
+CREATE INDEX IF NOT EXISTS transfers_timestamp_idx ON transfers (timestamp);
+
+CREATE TABLE IF NOT EXISTS balances (
+    token_id        TEXT REFERENCES tokens(id),
+    account         TEXT,
+    value           TEXT,
+    PRIMARY KEY (token_id, account)
+);
+
+CREATE INDEX IF NOT EXISTS balances_account_idx ON balances (account);
+
+CREATE TABLE IF NOT EXISTS balance_checkpoints (
+    token_id        TEXT REFERENCES tokens(id),
+    account         TEXT,
+    block_number    BIGINT,
+    value           TEXT,
+    PRIMARY KEY (token_id, account, block_number)
+);
+
+CREATE TABLE IF NOT EXISTS nft_transfers (
+    token_id        TEXT REFERENCES tokens(id),
+    nft_id          TEXT,
+    block_hash      TEXT,
+    block_number    BIGINT,
+    log_index       BIGINT,
+    txn_hash        TEXT,
+    txn_index       BIGINT,
+    timestamp       BIGINT,
+    from_addr       TEXT,
+    to_addr         TEXT,
+    confirmed       BOOLEAN NOT NULL DEFAULT 0,
+    UNIQUE (block_hash, log_index)
+);
+
+CREATE INDEX IF NOT EXISTS nft_transfers_nft_idx ON nft_transfers (token_id, nft_id, block_number);
+CREATE INDEX IF NOT EXISTS nft_transfers_block_idx ON nft_transfers (block_number, log_index);
+CREATE INDEX IF NOT EXISTS nft_transfers_pending_idx ON nft_transfers (block_number) WHERE confirmed = 0;
+
+CREATE TABLE IF NOT EXISTS nft_owners (
+    token_id        TEXT REFERENCES tokens(id),
+    nft_id          TEXT,
+    owner           TEXT,
+    PRIMARY KEY (token_id, nft_id)
+);
+
+CREATE INDEX IF NOT EXISTS nft_owners_owner_idx ON nft_owners (owner);
+
+CREATE TABLE IF NOT EXISTS multi_transfers (
+    token_id        TEXT REFERENCES tokens(id),
+    nft_id          TEXT,
+    block_hash      TEXT,
+    block_number    BIGINT,
+    log_index       BIGINT,
+    batch_index     BIGINT,
+    txn_hash        TEXT,
+    txn_index       BIGINT,
+    timestamp       BIGINT,
+    operator        TEXT,
+    from_addr       TEXT,
+    to_addr         TEXT,
+    value           TEXT,
+    confirmed       BOOLEAN NOT NULL DEFAULT 0,
+    UNIQUE (block_hash, log_index, batch_index)
+);
+
+CREATE INDEX IF NOT EXISTS multi_transfers_from_idx ON multi_transfers (from_addr, block_number);
+CREATE INDEX IF NOT EXISTS multi_transfers_to_idx ON multi_transfers (to_addr, block_number);
+CREATE INDEX IF NOT EXISTS multi_transfers_block_idx ON multi_transfers (block_number, log_index, batch_index);
+CREATE INDEX IF NOT EXISTS multi_transfers_pending_idx ON multi_transfers (block_number) WHERE confirmed = 0;
+
+CREATE TABLE IF NOT EXISTS multi_balances (
+    token_id        TEXT REFERENCES tokens(id),
+    nft_id          TEXT,
+    account         TEXT,
+    value           TEXT,
+    PRIMARY KEY (token_id, nft_id, account)
+);
+
+CREATE INDEX IF NOT EXISTS multi_balances_account_idx ON multi_balances (account);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id              TEXT PRIMARY KEY,
+    url             TEXT NOT NULL,
+    secret          TEXT NOT NULL,
+    tokens          TEXT NOT NULL DEFAULT '',
+    accounts        TEXT NOT NULL DEFAULT '',
+    confirmations   BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id      TEXT REFERENCES webhooks(id),
+    type            TEXT,
+    block_hash      TEXT,
+    block_number    BIGINT,
+    ready_block     BIGINT,
+    payload         TEXT,
+    status          TEXT,
+    attempts        BIGINT NOT NULL DEFAULT 0,
+    next_attempt    BIGINT NOT NULL DEFAULT 0,
+    last_error      TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (status, ready_block, next_attempt);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_block_idx ON webhook_deliveries (block_hash);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
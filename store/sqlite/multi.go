@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/jmoiron/sqlx"
+	"github.com/umbracle/go-web3"
+)
+
+func (s *Store) writeMultiTransfersImpl(tx *sqlx.Tx, transfers []*store.MultiTransfer) (bool, error) {
+	inserted := false
+	for _, transfer := range transfers {
+		if err := s.writeTokenImpl(tx, transfer.Addr); err != nil {
+			return false, err
+		}
+
+		query := "INSERT OR IGNORE INTO multi_transfers (token_id, nft_id, block_hash, block_number, log_index, batch_index, txn_hash, txn_index, timestamp, operator, from_addr, to_addr, value) VALUES (:token_id, :nft_id, :block_hash, :block_number, :log_index, :batch_index, :txn_hash, :txn_index, :timestamp, :operator, :from_addr, :to_addr, :value)"
+		res, err := tx.NamedExec(query, transfer)
+		if err != nil {
+			return false, err
+		}
+		num, err := res.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		if num == 0 {
+			// the log is already stored
+			continue
+		}
+		inserted = true
+		if err := s.updateMultiBalancesImpl(tx, transfer, false); err != nil {
+			return false, err
+		}
+	}
+	return inserted, nil
+}
+
+func (s *Store) updateMultiBalancesImpl(tx *sqlx.Tx, transfer *store.MultiTransfer, revert bool) error {
+	changes, err := store.MultiBalanceChanges(transfer, revert)
+	if err != nil {
+		return err
+	}
+	for _, b := range changes {
+		var current []string
+		if err := tx.Select(&current, "SELECT value FROM multi_balances WHERE token_id=? AND nft_id=? AND account=?", b.Token, b.TokenID, b.Account); err != nil {
+			return err
+		}
+		value, ok := new(big.Int).SetString(b.Value, 10)
+		if !ok {
+			return fmt.Errorf("cannot convert value '%s' to big.Int", b.Value)
+		}
+		if len(current) == 1 {
+			prev, ok := new(big.Int).SetString(current[0], 10)
+			if !ok {
+				return fmt.Errorf("cannot convert value '%s' to big.Int", current[0])
+			}
+			value.Add(value, prev)
+		}
+
+		if value.Sign() == 0 {
+			_, err = tx.Exec("DELETE FROM multi_balances WHERE token_id=? AND nft_id=? AND account=?", b.Token, b.TokenID, b.Account)
+		} else {
+			_, err = tx.Exec("INSERT OR REPLACE INTO multi_balances (token_id, nft_id, account, value) VALUES (?, ?, ?, ?)", b.Token, b.TokenID, b.Account, value.String())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) removeMultiTransfersImpl(tx *sqlx.Tx, blockHash web3.Hash) error {
+	transfers := []*store.MultiTransfer{}
+	if err := tx.Select(&transfers, "SELECT token_id, nft_id, from_addr, to_addr, value FROM multi_transfers WHERE block_hash=?", blockHash.String()); err != nil {
+		return err
+	}
+	for _, transfer := range transfers {
+		if err := s.updateMultiBalancesImpl(tx, transfer, true); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM multi_transfers WHERE block_hash=?", blockHash.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetMultiTransfers returns the ERC1155 transfers given a filter
+func (s *Store) GetMultiTransfers(filter store.TransfersFilter) ([]*store.MultiTransfer, error) {
+	query, args, err := filterQuery("SELECT token_id, nft_id, block_hash, block_number, log_index, batch_index, txn_hash, txn_index, timestamp, confirmed, operator, from_addr, to_addr, value FROM multi_transfers", filter, "value", batchOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := []*store.MultiTransfer{}
+	if err := s.db.Select(&transfers, query, args...); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// GetMultiBalances returns the ERC1155 balances of an account
+func (s *Store) GetMultiBalances(account web3.Address, tokens []web3.Address) ([]*store.MultiBalance, error) {
+	query := "SELECT token_id, nft_id, account, value FROM multi_balances WHERE account=?"
+	args := []interface{}{account.String()}
+	if len(tokens) != 0 {
+		query += " AND token_id IN (?)"
+		args = append(args, sliceAddressToString(tokens))
+	}
+	// the token ids are decimal numbers
+	query += " ORDER BY token_id, length(nft_id), nft_id"
+
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []*store.MultiBalance{}
+	if err := s.db.Select(&balances, query, args...); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
@@ -0,0 +1,710 @@
+package sqlite
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/gobuffalo/packr"
+	"github.com/jmoiron/sqlx"
+	"github.com/umbracle/go-web3"
+
+	// sqlite driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	defaultEndpoint = "tracker.sqlite"
+)
+
+// Factory is the factory method for the SQLite store
+func Factory(config map[string]interface{}) (store.Store, error) {
+	endpoint := defaultEndpoint
+
+	endpointRaw, ok := config["endpoint"]
+	if ok {
+		endpoint, ok = endpointRaw.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert endpoint to string")
+		}
+	}
+	return New(endpoint)
+}
+
+var (
+	ddl = packr.NewBox("./db")
+)
+
+// Store is a SQLite store for the tracker
+type Store struct {
+	db *sqlx.DB
+}
+
+// New creates a new store. The endpoint is the path of the database file.
+func New(endpoint string) (*Store, error) {
+	db, err := sqlx.Connect("sqlite3", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only supports a single writer at a time
+	db.SetMaxOpenConns(1)
+
+	s := &Store{db}
+	if err := s.setupDB(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// schemaColumns are the columns added to the tables after their first
+// version. They are added to the tables of the older versions that do
+// not have them, the transfers written before they were timestamped have
+// an unknown timestamp.
+var schemaColumns = []struct {
+	table, column, def string
+}{
+	{"tokens", "name", "TEXT NOT NULL DEFAULT ''"},
+	{"tokens", "symbol", "TEXT NOT NULL DEFAULT ''"},
+	{"tokens", "decimals", "INTEGER"},
+	{"tokens", "total_supply", "TEXT NOT NULL DEFAULT ''"},
+	{"tokens", "resolved", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"transfers", "block_number", "BIGINT"},
+	{"transfers", "log_index", "BIGINT"},
+	{"transfers", "txn_index", "BIGINT"},
+	{"transfers", "timestamp", "BIGINT DEFAULT 0"},
+	{"transfers", "confirmed", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"nft_transfers", "timestamp", "BIGINT DEFAULT 0"},
+	{"nft_transfers", "confirmed", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"multi_transfers", "timestamp", "BIGINT DEFAULT 0"},
+	{"multi_transfers", "confirmed", "BOOLEAN NOT NULL DEFAULT 0"},
+}
+
+// migrations upgrade the tables of the older versions before the schema is
+// applied. The migration at index i upgrades the database from the version
+// i, the version of the database is stored in its user_version.
+var migrations = []func(tx *sqlx.Tx) error{
+	upgradeTables,
+}
+
+// tableColumns returns the columns of a table or none if it does not exist
+func tableColumns(tx *sqlx.Tx, table string) ([]string, error) {
+	columns := []string{}
+	if err := tx.Select(&columns, "SELECT name FROM pragma_table_info(?)", table); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// upgradeTables upgrades the tables created before the database was
+// versioned. The tables that do not exist are created by the schema.
+func upgradeTables(tx *sqlx.Tx) error {
+	for _, c := range schemaColumns {
+		columns, err := tableColumns(tx, c.table)
+		if err != nil {
+			return err
+		}
+		found := len(columns) == 0
+		for _, column := range columns {
+			found = found || column == c.column
+		}
+		if found {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.def)); err != nil {
+			return err
+		}
+	}
+
+	columns, err := tableColumns(tx, "transfers")
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	// the transfers written before their position was recorded cannot be
+	// upgraded
+	var legacy int
+	if err := tx.Get(&legacy, "SELECT count(*) FROM transfers WHERE block_number IS NULL"); err != nil {
+		return err
+	}
+	if legacy != 0 {
+		return store.ErrLegacyTransfers
+	}
+
+	// the logs written more than once before the ingestion was idempotent
+	// are removed before the transfers are unique
+	var unique int
+	if err := tx.Get(&unique, "SELECT count(*) FROM pragma_index_list('transfers') WHERE \"unique\" = 1"); err != nil {
+		return err
+	}
+	if unique == 0 {
+		query := "DELETE FROM transfers WHERE rowid NOT IN (SELECT min(rowid) FROM transfers GROUP BY block_hash, log_index)"
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("CREATE UNIQUE INDEX transfers_log_idx ON transfers (block_hash, log_index)"); err != nil {
+			return err
+		}
+	}
+
+	// the block index of the transfers did not include the log index, it
+	// is created again by the schema
+	if _, err := tx.Exec("DROP INDEX IF EXISTS transfers_block_idx"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// setupDB migrates the database to the last version and applies the
+// schema. It is run on every start.
+func (s *Store) setupDB() error {
+	var version int
+	if err := s.db.Get(&version, "PRAGMA user_version"); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("the database version %d is newer than the store version %d", version, len(migrations))
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, migrate := range migrations[version:] {
+		if err := migrate(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec(ddl.String("./schema/schema.sql")); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// WriteBlocks writes the blocks of the receipts
+func (s *Store) WriteBlocks(blocks []*store.Block) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if _, err := tx.NamedExec("INSERT OR IGNORE INTO blocks (hash, number, timestamp) VALUES (:hash, :number, :timestamp)", b); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// blockTimestampImpl returns the time of the block or zero if the block is
+// not stored
+func (s *Store) blockTimestampImpl(tx *sqlx.Tx, blockHash web3.Hash) (uint64, error) {
+	timestamps := []uint64{}
+	if err := tx.Select(&timestamps, "SELECT timestamp FROM blocks WHERE hash=?", blockHash.String()); err != nil {
+		return 0, err
+	}
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
+	return timestamps[0], nil
+}
+
+// WriteReceipt writes a new receipt and returns the logs written. Logs that
+// are already stored are ignored
+func (s *Store) WriteReceipt(logs []*web3.Log) ([]*web3.Log, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	written := []*web3.Log{}
+	timestamps := map[web3.Hash]uint64{}
+	for _, log := range logs {
+		timestamp, ok := timestamps[log.BlockHash]
+		if !ok {
+			if timestamp, err = s.blockTimestampImpl(tx, log.BlockHash); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			timestamps[log.BlockHash] = timestamp
+		}
+		inserted, err := s.writeLogImpl(tx, log, timestamp)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if inserted {
+			written = append(written, log)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+// writeLogImpl writes the transfers of a log. It returns false if the log
+// is already stored or it is not a token transfer.
+func (s *Store) writeLogImpl(tx *sqlx.Tx, log *web3.Log, timestamp uint64) (bool, error) {
+	transfer, err := store.ParseTransfer(log)
+	if err != nil {
+		return false, err
+	}
+	if transfer != nil {
+		transfer.Timestamp = timestamp
+		return s.writeTransferImpl(tx, transfer)
+	}
+	nftTransfer, err := store.ParseNFTTransfer(log)
+	if err != nil {
+		return false, err
+	}
+	if nftTransfer != nil {
+		nftTransfer.Timestamp = timestamp
+		return s.writeNFTTransferImpl(tx, nftTransfer)
+	}
+	multiTransfers, err := store.ParseMultiTransfers(log)
+	if err != nil {
+		return false, err
+	}
+	if multiTransfers != nil {
+		for _, t := range multiTransfers {
+			t.Timestamp = timestamp
+		}
+		return s.writeMultiTransfersImpl(tx, multiTransfers)
+	}
+	// non-standard token
+	return false, nil
+}
+
+func (s *Store) writeTokenImpl(tx *sqlx.Tx, token string) error {
+	if _, err := tx.Exec("INSERT OR IGNORE INTO tokens (id) VALUES (?)", token); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) writeTransferImpl(tx *sqlx.Tx, transfer *store.Transfer) (bool, error) {
+	if err := s.writeTokenImpl(tx, transfer.Addr); err != nil {
+		return false, err
+	}
+
+	query := "INSERT OR IGNORE INTO transfers (token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, from_addr, to_addr, value) VALUES (:token_id, :block_hash, :block_number, :log_index, :txn_hash, :txn_index, :timestamp, :from_addr, :to_addr, :value)"
+	res, err := tx.NamedExec(query, transfer)
+	if err != nil {
+		return false, err
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if num == 0 {
+		// the log is already stored
+		return false, nil
+	}
+	return true, s.updateBalancesImpl(tx, transfer, false)
+}
+
+func (s *Store) updateBalancesImpl(tx *sqlx.Tx, transfer *store.Transfer, revert bool) error {
+	changes, err := store.BalanceChanges(transfer, revert)
+	if err != nil {
+		return err
+	}
+	for _, b := range changes {
+		// sqlite does not have a type for big numbers, do the math here
+		var current []string
+		if err := tx.Select(&current, "SELECT value FROM balances WHERE token_id=? AND account=?", b.Token, b.Account); err != nil {
+			return err
+		}
+		value, ok := new(big.Int).SetString(b.Value, 10)
+		if !ok {
+			return fmt.Errorf("cannot convert value '%s' to big.Int", b.Value)
+		}
+		if len(current) == 1 {
+			prev, ok := new(big.Int).SetString(current[0], 10)
+			if !ok {
+				return fmt.Errorf("cannot convert value '%s' to big.Int", current[0])
+			}
+			value.Add(value, prev)
+		}
+
+		if value.Sign() == 0 {
+			_, err = tx.Exec("DELETE FROM balances WHERE token_id=? AND account=?", b.Token, b.Account)
+		} else {
+			_, err = tx.Exec("INSERT OR REPLACE INTO balances (token_id, account, value) VALUES (?, ?, ?)", b.Token, b.Account, value.String())
+		}
+		if err != nil {
+			return err
+		}
+		if revert {
+			// the checkpoints of the reverted blocks are removed afterwards
+			continue
+		}
+		if err := s.writeCheckpointImpl(tx, b.Token, b.Account, transfer.BlockNumber, value.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type checkpoint struct {
+	BlockNumber uint64 `db:"block_number"`
+	Value       string `db:"value"`
+}
+
+// writeCheckpointImpl updates the checkpoint of the balance at the block if
+// it exists or if the last one is more than CheckpointInterval blocks away.
+// It expects the receipts to be written in block order.
+func (s *Store) writeCheckpointImpl(tx *sqlx.Tx, token, account string, blockNumber uint64, value string) error {
+	last := []uint64{}
+	if err := tx.Select(&last, "SELECT block_number FROM balance_checkpoints WHERE token_id=? AND account=? ORDER BY block_number DESC LIMIT 1", token, account); err != nil {
+		return err
+	}
+	if len(last) == 1 && last[0] != blockNumber && blockNumber < last[0]+store.CheckpointInterval {
+		return nil
+	}
+	query := "INSERT OR REPLACE INTO balance_checkpoints (token_id, account, block_number, value) VALUES (?, ?, ?, ?)"
+	if _, err := tx.Exec(query, token, account, blockNumber, value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close closes the storage
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the store can be reached
+func (s *Store) Ping() error {
+	return s.db.Ping()
+}
+
+// RemoveReceipts removes the receipts by block hash
+func (s *Store) RemoveReceipts(blockHash web3.Hash) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := s.removeReceiptsImpl(tx, blockHash); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) removeReceiptsImpl(tx *sqlx.Tx, blockHash web3.Hash) error {
+	transfers := []*store.Transfer{}
+	if err := tx.Select(&transfers, "SELECT token_id, block_number, from_addr, to_addr, value FROM transfers WHERE block_hash=?", blockHash.String()); err != nil {
+		return err
+	}
+	for _, transfer := range transfers {
+		if err := s.updateBalancesImpl(tx, transfer, true); err != nil {
+			return err
+		}
+	}
+	if len(transfers) != 0 {
+		// checkpoints are only a cache, it is safe to remove any of them
+		if _, err := tx.Exec("DELETE FROM balance_checkpoints WHERE block_number >= ?", transfers[0].BlockNumber); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM transfers WHERE block_hash=?", blockHash.String()); err != nil {
+		return err
+	}
+	if err := s.removeNFTTransfersImpl(tx, blockHash); err != nil {
+		return err
+	}
+	if err := s.removeMultiTransfersImpl(tx, blockHash); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM blocks WHERE hash=?", blockHash.String()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ConfirmTransfers marks as confirmed the transfers up to the block
+func (s *Store) ConfirmTransfers(blockNumber uint64) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := s.confirmTransfersImpl(tx, blockNumber); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) confirmTransfersImpl(tx *sqlx.Tx, blockNumber uint64) error {
+	if _, err := tx.Exec("UPDATE transfers SET confirmed = 1 WHERE confirmed = 0 AND block_number <= ?", blockNumber); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE nft_transfers SET confirmed = 1 WHERE confirmed = 0 AND block_number <= ?", blockNumber); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE multi_transfers SET confirmed = 1 WHERE confirmed = 0 AND block_number <= ?", blockNumber); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListTokens returns the list of registered tokens
+func (s *Store) ListTokens(p store.QueryPagination) ([]*store.Token, error) {
+	query := "SELECT id, name, symbol, decimals, total_supply, resolved FROM tokens"
+	args := []interface{}{}
+	if p.Cursor != "" {
+		token, err := store.DecodeTokenCursor(p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query += " WHERE id > ?"
+		args = append(args, token)
+	}
+	query += " ORDER BY id"
+
+	// the offset is not used with a cursor
+	if p.Limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, p.Limit)
+		if p.Cursor == "" {
+			query += " OFFSET ?"
+			args = append(args, p.Offset)
+		}
+	}
+
+	tokens := []*store.Token{}
+	if err := s.db.Select(&tokens, query, args...); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetToken returns a token and its metadata
+func (s *Store) GetToken(token web3.Address) (*store.Token, error) {
+	tokens := []*store.Token{}
+	if err := s.db.Select(&tokens, "SELECT id, name, symbol, decimals, total_supply, resolved FROM tokens WHERE id=?", token.String()); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return tokens[0], nil
+}
+
+// WriteTokenMetadata updates the metadata of a token
+func (s *Store) WriteTokenMetadata(token *store.Token) error {
+	query := "UPDATE tokens SET name=:name, symbol=:symbol, decimals=:decimals, total_supply=:total_supply, resolved=:resolved WHERE id=:id"
+	if _, err := s.db.NamedExec(query, token); err != nil {
+		return err
+	}
+	return nil
+}
+
+func sliceAddressToString(w []web3.Address) []string {
+	resp := []string{}
+	for _, i := range w {
+		resp = append(resp, i.String())
+	}
+	return resp
+}
+
+var (
+	// logOrder are the columns that sort the transfers in chain order
+	logOrder = []string{"block_number", "log_index"}
+
+	// batchOrder are the columns that sort the erc1155 transfers in
+	// chain order
+	batchOrder = []string{"block_number", "log_index", "batch_index"}
+)
+
+// descending returns the columns in descending order
+func descending(columns []string) []string {
+	res := []string{}
+	for _, column := range columns {
+		res = append(res, column+" DESC")
+	}
+	return res
+}
+
+// filterQuery adds to the query the conditions, the order and the pagination
+// of a transfers filter. The transfers are sorted by the order columns or by
+// the value column first if they are sorted by value.
+func filterQuery(query string, filter store.TransfersFilter, value string, order []string) (string, []interface{}, error) {
+	whereAttr := []string{}
+	args := []interface{}{}
+	// filter by from
+	if len(filter.From) != 0 {
+		whereAttr = append(whereAttr, "from_addr IN (?)")
+		args = append(args, sliceAddressToString(filter.From))
+	}
+	// filter by to
+	if len(filter.To) != 0 {
+		whereAttr = append(whereAttr, "to_addr IN (?)")
+		args = append(args, sliceAddressToString(filter.To))
+	}
+	// filter by tokens
+	if len(filter.Tokens) != 0 {
+		whereAttr = append(whereAttr, "token_id IN (?)")
+		args = append(args, sliceAddressToString(filter.Tokens))
+	}
+	// filter by block and time ranges
+	ranges := []struct {
+		column    string
+		low, high uint64
+	}{
+		{"block_number", filter.FromBlock, filter.ToBlock},
+		{"timestamp", filter.FromTime, filter.ToTime},
+	}
+	for _, r := range ranges {
+		if r.low != 0 {
+			whereAttr = append(whereAttr, r.column+" >= ?")
+			args = append(args, r.low)
+		}
+		if r.high != 0 {
+			whereAttr = append(whereAttr, r.column+" <= ?")
+			args = append(args, r.high)
+		}
+	}
+	if filter.FromTime == 0 && filter.ToTime != 0 {
+		// the transfers with an unknown timestamp are not in the range
+		whereAttr = append(whereAttr, "timestamp <> 0")
+	}
+	// filter by confirmation
+	switch filter.Status {
+	case store.StatusConfirmed:
+		whereAttr = append(whereAttr, "confirmed = 1")
+	case store.StatusPending:
+		whereAttr = append(whereAttr, "confirmed = 0")
+	}
+	// start after the cursor
+	cursor, err := store.DecodeTransfersCursor(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	columns := order
+	if filter.Sort == store.SortValue {
+		// the values are decimal numbers
+		columns = append([]string{"length(" + value + ")", value}, order...)
+	}
+	if cursor != nil {
+		vals := []interface{}{cursor.BlockNumber, cursor.LogIndex, cursor.BatchIndex}[:len(order)]
+		if filter.Sort == store.SortValue {
+			vals = append([]interface{}{len(cursor.Value), cursor.Value}, vals...)
+		}
+		op := ">"
+		if filter.Desc {
+			op = "<"
+		}
+		whereAttr = append(whereAttr, "("+strings.Join(columns, ", ")+") "+op+" ("+strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")+")")
+		args = append(args, vals...)
+	}
+	if len(whereAttr) != 0 {
+		query += " WHERE " + strings.Join(whereAttr, " AND ")
+	}
+	if filter.Desc {
+		columns = descending(columns)
+	}
+	query += " ORDER BY " + strings.Join(columns, ", ")
+
+	// add the pagination, the offset is not used with a cursor
+	if filter.Limit != 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+		if filter.Cursor == "" {
+			query += " OFFSET ?"
+			args = append(args, filter.Offset)
+		}
+	}
+
+	// expand the IN clauses
+	return sqlx.In(query, args...)
+}
+
+// GetTokenTransfers returns the transfers given a filter
+func (s *Store) GetTokenTransfers(filter store.TransfersFilter) ([]*store.Transfer, error) {
+	query, args, err := filterQuery("SELECT token_id, block_hash, block_number, log_index, txn_hash, txn_index, timestamp, confirmed, from_addr, to_addr, value FROM transfers", filter, "value", logOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := []*store.Transfer{}
+	if err := s.db.Select(&transfers, query, args...); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// GetBalances returns the balances of an account
+func (s *Store) GetBalances(account web3.Address, tokens []web3.Address) ([]*store.Balance, error) {
+	query := "SELECT token_id, account, value FROM balances WHERE account=?"
+	args := []interface{}{account.String()}
+	if len(tokens) != 0 {
+		query += " AND token_id IN (?)"
+		args = append(args, sliceAddressToString(tokens))
+	}
+	query += " ORDER BY token_id"
+
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []*store.Balance{}
+	if err := s.db.Select(&balances, query, args...); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// GetBalanceAt returns the balance of an account after a given block
+func (s *Store) GetBalanceAt(token, account web3.Address, blockNumber uint64) (*store.Balance, error) {
+	checkpoints := []*checkpoint{}
+	query := "SELECT block_number, value FROM balance_checkpoints WHERE token_id=? AND account=? AND block_number <= ? ORDER BY block_number DESC LIMIT 1"
+	if err := s.db.Select(&checkpoints, query, token.String(), account.String(), blockNumber); err != nil {
+		return nil, err
+	}
+
+	balance := big.NewInt(0)
+	from := uint64(0)
+	if len(checkpoints) == 1 {
+		if _, ok := balance.SetString(checkpoints[0].Value, 10); !ok {
+			return nil, fmt.Errorf("cannot convert value '%s' to big.Int", checkpoints[0].Value)
+		}
+		from = checkpoints[0].BlockNumber + 1
+	}
+
+	transfers := []*store.Transfer{}
+	query = "SELECT token_id, from_addr, to_addr, value FROM transfers WHERE token_id=? AND (from_addr=? OR to_addr=?) AND block_number >= ? AND block_number <= ?"
+	if err := s.db.Select(&transfers, query, token.String(), account.String(), account.String(), from, blockNumber); err != nil {
+		return nil, err
+	}
+	if err := store.ApplyTransfers(account.String(), balance, transfers); err != nil {
+		return nil, err
+	}
+
+	b := &store.Balance{
+		Token:   token.String(),
+		Account: account.String(),
+		Value:   balance.String(),
+	}
+	return b, nil
+}
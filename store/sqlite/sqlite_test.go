@@ -0,0 +1,187 @@
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/jmoiron/sqlx"
+)
+
+func testSQLite(t *testing.T) (store.Store, func()) {
+	dir, err := ioutil.TempDir("", "tracker-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(filepath.Join(dir, "tracker.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	close := func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+	return s, close
+}
+
+func TestStore(t *testing.T) {
+	store.TestStore(t, testSQLite)
+}
+
+// baselineSchema is the schema of the first version of the store
+var baselineSchema = `
+CREATE TABLE tokens (
+    id TEXT PRIMARY KEY
+);
+
+CREATE TABLE transfers (
+    token_id        TEXT REFERENCES tokens(id),
+    block_hash      TEXT,
+    txn_hash        TEXT,
+    from_addr       TEXT,
+    to_addr         TEXT,
+    value           TEXT
+);
+`
+
+// positionSchema is the schema of the version that recorded the position
+// of the transfers in the chain
+var positionSchema = `
+CREATE TABLE tokens (
+    id TEXT PRIMARY KEY
+);
+
+CREATE TABLE transfers (
+    token_id        TEXT REFERENCES tokens(id),
+    block_hash      TEXT,
+    block_number    BIGINT,
+    log_index       BIGINT,
+    txn_hash        TEXT,
+    txn_index       BIGINT,
+    from_addr       TEXT,
+    to_addr         TEXT,
+    value           TEXT
+);
+`
+
+// newOlderDB creates a database with the schema of an older version and
+// runs the statements on it
+func newOlderDB(t *testing.T, schema string, stmts ...string) (string, func()) {
+	dir, err := ioutil.TempDir("", "tracker-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "tracker.sqlite")
+
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, stmt := range append([]string{schema}, stmts...) {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// testBaseline creates a database with the baseline schema and opens it
+// with the store, that upgrades it
+func testBaseline(t *testing.T) (store.Store, func()) {
+	path, remove := newOlderDB(t, baselineSchema)
+
+	// the schema can be applied again
+	var s *Store
+	var err error
+	for i := 0; i < 2; i++ {
+		if s, err = New(path); err != nil {
+			t.Fatal(err)
+		}
+		var version int
+		if err := s.db.Get(&version, "PRAGMA user_version"); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(migrations) {
+			t.Fatalf("bad version %d", version)
+		}
+		if i == 0 {
+			s.Close()
+		}
+	}
+	close := func() {
+		s.Close()
+		remove()
+	}
+	return s, close
+}
+
+func TestUpgradeSchema(t *testing.T) {
+	store.TestStore(t, testBaseline)
+}
+
+func TestUpgradeTransfers(t *testing.T) {
+	path, remove := newOlderDB(t, positionSchema,
+		"INSERT INTO tokens (id) VALUES ('0x1')",
+		"INSERT INTO transfers (token_id, block_hash, block_number, log_index, txn_hash, txn_index, from_addr, to_addr, value) VALUES ('0x1', '0xa', 1, 0, '0xb', 0, '0x2', '0x3', '1')",
+	)
+	defer remove()
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the transfers are listed and confirmed with an unknown timestamp
+	if err := s.ConfirmTransfers(1); err != nil {
+		t.Fatal(err)
+	}
+	transfers, err := s.GetTokenTransfers(store.TransfersFilter{Status: store.StatusConfirmed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 {
+		t.Fatalf("expected 1 transfer but found %d", len(transfers))
+	}
+	if transfer := transfers[0]; transfer.BlockNumber != 1 || transfer.Timestamp != 0 || transfer.Value != "1" {
+		t.Fatal("bad transfer")
+	}
+}
+
+func TestUpgradeLegacyTransfers(t *testing.T) {
+	path, remove := newOlderDB(t, baselineSchema,
+		"INSERT INTO tokens (id) VALUES ('0x1')",
+		"INSERT INTO transfers (token_id, block_hash, txn_hash, from_addr, to_addr, value) VALUES ('0x1', '0xa', '0xb', '0x2', '0x3', '1')",
+	)
+	defer remove()
+
+	// the transfers without a position cannot be upgraded
+	if _, err := New(path); err != store.ErrLegacyTransfers {
+		t.Fatalf("expected legacy transfers but found %v", err)
+	}
+
+	// and the database is not changed
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var version int
+	if err := db.Get(&version, "PRAGMA user_version"); err != nil {
+		t.Fatal(err)
+	}
+	var tables int
+	if err := db.Get(&tables, "SELECT count(*) FROM sqlite_master WHERE type='table'"); err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 || tables != 2 {
+		t.Fatal("the database is upgraded")
+	}
+}
@@ -0,0 +1,190 @@
+package sqlite
+
+import (
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/jmoiron/sqlx"
+)
+
+// CreateWebhook stores a new webhook
+func (s *Store) CreateWebhook(w *store.Webhook) error {
+	query := "INSERT INTO webhooks (id, url, secret, tokens, accounts, confirmations) VALUES (:id, :url, :secret, :tokens, :accounts, :confirmations)"
+	if _, err := s.db.NamedExec(query, w); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetWebhook returns a webhook
+func (s *Store) GetWebhook(id string) (*store.Webhook, error) {
+	webhooks := []*store.Webhook{}
+	if err := s.db.Select(&webhooks, "SELECT id, url, secret, tokens, accounts, confirmations FROM webhooks WHERE id=?", id); err != nil {
+		return nil, err
+	}
+	if len(webhooks) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return webhooks[0], nil
+}
+
+// ListWebhooks returns all the webhooks sorted by id
+func (s *Store) ListWebhooks() ([]*store.Webhook, error) {
+	webhooks := []*store.Webhook{}
+	if err := s.db.Select(&webhooks, "SELECT id, url, secret, tokens, accounts, confirmations FROM webhooks ORDER BY id"); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func (s *Store) DeleteWebhook(id string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := s.deleteWebhookImpl(tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) deleteWebhookImpl(tx *sqlx.Tx, id string) error {
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id=?", id); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM webhooks WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// WriteDeliveries stores new pending deliveries and skips the transfer
+// deliveries already queued
+func (s *Store) WriteDeliveries(deliveries []*store.Delivery) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO webhook_deliveries (webhook_id, type, block_hash, block_number, ready_block, payload, status, attempts, next_attempt, last_error) VALUES (:webhook_id, :type, :block_hash, :block_number, :ready_block, :payload, :status, :attempts, :next_attempt, :last_error)"
+	for _, d := range deliveries {
+		if d.Type == store.DeliveryTransfer {
+			queued, err := s.queuedImpl(tx, d.WebhookID, d.BlockHash)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if queued {
+				continue
+			}
+		}
+		if _, err := tx.NamedExec(query, d); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// queuedImpl returns true if the webhook has a transfer delivery of the
+// block that was not reversed
+func (s *Store) queuedImpl(tx *sqlx.Tx, webhookID, blockHash string) (bool, error) {
+	var queued int
+	query := "SELECT COALESCE(SUM(CASE WHEN type=? THEN 1 ELSE -1 END), 0) FROM webhook_deliveries WHERE webhook_id=? AND block_hash=?"
+	if err := tx.Get(&queued, query, store.DeliveryTransfer, webhookID, blockHash); err != nil {
+		return false, err
+	}
+	return queued > 0, nil
+}
+
+// PendingDeliveries returns the pending deliveries that are ready
+func (s *Store) PendingDeliveries(head, now uint64, limit int) ([]*store.Delivery, error) {
+	query := "SELECT id, webhook_id, type, block_hash, block_number, ready_block, payload, status, attempts, next_attempt, last_error FROM webhook_deliveries WHERE status=? AND ready_block <= ? AND next_attempt <= ? ORDER BY id LIMIT ?"
+
+	deliveries := []*store.Delivery{}
+	if err := s.db.Select(&deliveries, query, store.DeliveryPending, head, now, limit); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery updates the state of a delivery
+func (s *Store) UpdateDelivery(d *store.Delivery) error {
+	query := "UPDATE webhook_deliveries SET status=:status, attempts=:attempts, next_attempt=:next_attempt, last_error=:last_error WHERE id=:id"
+	res, err := s.db.NamedExec(query, d)
+	if err != nil {
+		return err
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if num == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// RevertDeliveries removes the pending transfer deliveries of a block and
+// returns the delivered ones
+func (s *Store) RevertDeliveries(blockHash string) ([]*store.Delivery, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.revertDeliveriesImpl(tx, blockHash)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (s *Store) revertDeliveriesImpl(tx *sqlx.Tx, blockHash string) ([]*store.Delivery, error) {
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE block_hash=? AND type=? AND status=?", blockHash, store.DeliveryTransfer, store.DeliveryPending); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT id, webhook_id, type, block_hash, block_number, ready_block, payload, status, attempts, next_attempt, last_error FROM webhook_deliveries WHERE block_hash=? AND type=? AND status=? ORDER BY id"
+
+	deliveries := []*store.Delivery{}
+	if err := tx.Select(&deliveries, query, blockHash, store.DeliveryTransfer, store.DeliveryDelivered); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ListDeliveries returns the deliveries of a webhook, the most recent first
+func (s *Store) ListDeliveries(webhookID string, p store.QueryPagination) ([]*store.Delivery, error) {
+	query := "SELECT id, webhook_id, type, block_hash, block_number, ready_block, payload, status, attempts, next_attempt, last_error FROM webhook_deliveries WHERE webhook_id=? ORDER BY id DESC"
+	args := []interface{}{webhookID}
+	if p.Limit != 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, p.Limit, p.Offset)
+	}
+
+	deliveries := []*store.Delivery{}
+	if err := s.db.Select(&deliveries, query, args...); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
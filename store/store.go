@@ -1,54 +1,310 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/umbracle/go-web3"
 )
 
+// ErrNotFound is returned when the requested object is not in the store
+var ErrNotFound = errors.New("not found")
+
+// ErrLegacyTransfers is returned when the store is upgraded with transfers
+// written by a version that did not record their position in the chain.
+// They cannot be upgraded and they are indexed again from an empty store.
+var ErrLegacyTransfers = errors.New("the store has transfers without a block number and a log index written by an older version, remove the store and the tracker dbpath to index them again")
+
+// ZeroAddress is the source of the mints and the destination of the burns.
+// Its balance is not tracked.
+var ZeroAddress = web3.Address{}.String()
+
+// Token is the model for a token and its metadata. The metadata is resolved
+// from the token contract once the token is first seen. Decimals is nil if
+// the token does not report them.
+type Token struct {
+	Addr        string  `db:"id"`
+	Name        string  `db:"name"`
+	Symbol      string  `db:"symbol"`
+	Decimals    *uint64 `db:"decimals"`
+	TotalSupply string  `db:"total_supply"`
+	Resolved    bool    `db:"resolved"`
+}
+
+// Block is the model for a block with transfers
+type Block struct {
+	Hash      string `db:"hash"`
+	Number    uint64 `db:"number"`
+	Timestamp uint64 `db:"timestamp"`
+}
+
+// LogPosition is the position in the chain of the log that emitted an event.
+// Timestamp is the time of the block or zero if the block is not stored.
+// Confirmed is set once the block has enough confirmations.
+type LogPosition struct {
+	BlockHash   string `db:"block_hash"`
+	TxnHash     string `db:"txn_hash"`
+	BlockNumber uint64 `db:"block_number"`
+	LogIndex    uint64 `db:"log_index"`
+	TxnIndex    uint64 `db:"txn_index"`
+	Timestamp   uint64 `db:"timestamp"`
+	Confirmed   bool   `db:"confirmed"`
+}
+
 // Transfer is the model for a token transfer
 type Transfer struct {
+	LogPosition
+
 	Addr  string `db:"token_id"`
 	From  string `db:"from_addr"`
 	To    string `db:"to_addr"`
 	Value string `db:"value"`
 }
 
-// QueryPagination represents a database pagination query
+// NFTTransfer is the model for an ERC721 token transfer
+type NFTTransfer struct {
+	LogPosition
+
+	Addr    string `db:"token_id"`
+	From    string `db:"from_addr"`
+	To      string `db:"to_addr"`
+	TokenID string `db:"nft_id"`
+}
+
+// NFTOwner is the model for the current owner of an ERC721 token
+type NFTOwner struct {
+	Addr    string `db:"token_id"`
+	TokenID string `db:"nft_id"`
+	Owner   string `db:"owner"`
+}
+
+// MultiTransfer is the model for an ERC1155 token transfer. A batch transfer
+// is stored as one transfer for each token id, in the order of the batch.
+type MultiTransfer struct {
+	LogPosition
+	BatchIndex uint64 `db:"batch_index"`
+
+	Addr     string `db:"token_id"`
+	Operator string `db:"operator"`
+	From     string `db:"from_addr"`
+	To       string `db:"to_addr"`
+	TokenID  string `db:"nft_id"`
+	Value    string `db:"value"`
+}
+
+// MultiBalance is the model for the balance of an account in an ERC1155
+// token id
+type MultiBalance struct {
+	Token   string `db:"token_id"`
+	TokenID string `db:"nft_id"`
+	Account string `db:"account"`
+	Value   string `db:"value"`
+}
+
+// Balance is the model for the balance of an account in a token
+type Balance struct {
+	Token   string `db:"token_id"`
+	Account string `db:"account"`
+	Value   string `db:"value"`
+}
+
+// BalanceChanges returns the changes in the balances produced by a transfer.
+// If revert is set, it returns the changes that undo the transfer.
+func BalanceChanges(t *Transfer, revert bool) ([]*Balance, error) {
+	value, ok := new(big.Int).SetString(t.Value, 10)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert value '%s' to big.Int", t.Value)
+	}
+	if revert {
+		value.Neg(value)
+	}
+
+	changes := []*Balance{}
+	if t.From != ZeroAddress {
+		changes = append(changes, &Balance{
+			Token:   t.Addr,
+			Account: t.From,
+			Value:   new(big.Int).Neg(value).String(),
+		})
+	}
+	if t.To != ZeroAddress {
+		changes = append(changes, &Balance{
+			Token:   t.Addr,
+			Account: t.To,
+			Value:   value.String(),
+		})
+	}
+	return changes, nil
+}
+
+// MultiBalanceChanges returns the changes in the balances produced by an
+// ERC1155 transfer. If revert is set, it returns the changes that undo the
+// transfer.
+func MultiBalanceChanges(t *MultiTransfer, revert bool) ([]*MultiBalance, error) {
+	changes, err := BalanceChanges(&Transfer{Addr: t.Addr, From: t.From, To: t.To, Value: t.Value}, revert)
+	if err != nil {
+		return nil, err
+	}
+	res := []*MultiBalance{}
+	for _, c := range changes {
+		res = append(res, &MultiBalance{
+			Token:   c.Token,
+			TokenID: t.TokenID,
+			Account: c.Account,
+			Value:   c.Value,
+		})
+	}
+	return res, nil
+}
+
+// CheckpointInterval is the minimum number of blocks between two checkpoints
+// of the balance of an account. The balance at a given block is computed
+// from the closest checkpoint and the transfers after it.
+const CheckpointInterval = 1000
+
+// ApplyTransfers adds to the balance of the account the changes produced
+// by the transfers.
+func ApplyTransfers(account string, balance *big.Int, transfers []*Transfer) error {
+	for _, t := range transfers {
+		changes, err := BalanceChanges(t, false)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if c.Account != account {
+				continue
+			}
+			value, ok := new(big.Int).SetString(c.Value, 10)
+			if !ok {
+				return fmt.Errorf("cannot convert value '%s' to big.Int", c.Value)
+			}
+			balance.Add(balance, value)
+		}
+	}
+	return nil
+}
+
+// QueryPagination represents a database pagination query. Cursor is the
+// opaque position of the last element of the previous page, the page starts
+// after it. Offset is only applied if there is no cursor.
 type QueryPagination struct {
 	Limit  int
 	Offset int
+	Cursor string
 }
 
-func (q *QueryPagination) String() string {
-	if q.Limit == 0 {
-		return ""
-	}
-	return fmt.Sprintf(" LIMIT %d OFFSET %d", q.Limit, q.Offset)
-}
+// SortField is the field used to sort the transfers
+type SortField string
+
+const (
+	// SortBlock sorts the transfers in chain order
+	SortBlock SortField = "block"
 
-func sliceAddressToString(w []web3.Address) []string {
-	resp := []string{}
-	for _, i := range w {
-		resp = append(resp, i.String())
+	// SortValue sorts the transfers by value and then in chain order. The
+	// ERC721 transfers are sorted by token id.
+	SortValue SortField = "value"
+)
+
+// TransferStatus is the confirmation status of a transfer
+type TransferStatus string
+
+const (
+	// StatusAll matches the confirmed and the pending transfers
+	StatusAll TransferStatus = ""
+
+	// StatusConfirmed matches the transfers with enough confirmations
+	StatusConfirmed TransferStatus = "confirmed"
+
+	// StatusPending matches the transfers that can still be reverted
+	StatusPending TransferStatus = "pending"
+)
+
+// Match returns true if a transfer with the confirmation matches the status
+func (s TransferStatus) Match(confirmed bool) bool {
+	switch s {
+	case StatusConfirmed:
+		return confirmed
+	case StatusPending:
+		return !confirmed
+	default:
+		return true
 	}
-	return resp
 }
 
-// TransfersFilter is the filter for a token transfer
+// TransfersFilter is the filter for a token transfer. The block and time
+// ranges are inclusive and a zero value means no bound. The transfers with
+// an unknown timestamp are not in any time range. The transfers are
+// sorted in ascending chain order unless Sort and Desc are set.
 type TransfersFilter struct {
 	QueryPagination
 
 	From   []web3.Address
 	To     []web3.Address
 	Tokens []web3.Address
+
+	FromBlock uint64
+	ToBlock   uint64
+	FromTime  uint64
+	ToTime    uint64
+
+	Sort SortField
+	Desc bool
+
+	Status TransferStatus
 }
 
 // Store is the interface to access the store
 type Store interface {
-	WriteReceipt(logs []*web3.Log) error
+	// WriteBlocks stores the blocks of the receipts. The blocks have to
+	// be written before their receipts to timestamp the transfers.
+	WriteBlocks(blocks []*Block) error
+
+	// WriteReceipt writes the transfers of the logs and returns the logs
+	// written. The logs that are already stored are ignored.
+	WriteReceipt(logs []*web3.Log) ([]*web3.Log, error)
 	RemoveReceipts(blockHash web3.Hash) error
 	Close() error
-	ListTokens(p QueryPagination) ([]string, error)
+
+	// ConfirmTransfers marks as confirmed the transfers up to the block.
+	// The transfers are written as pending.
+	ConfirmTransfers(blockNumber uint64) error
+
+	// Ping returns an error if the store cannot be reached
+	Ping() error
+
+	ListTokens(p QueryPagination) ([]*Token, error)
+
+	// GetToken returns a token or ErrNotFound if the token is not tracked
+	GetToken(token web3.Address) (*Token, error)
+
+	// WriteTokenMetadata updates the metadata of a tracked token
+	WriteTokenMetadata(token *Token) error
+
 	GetTokenTransfers(filter TransfersFilter) ([]*Transfer, error)
+
+	// GetBalances returns the non-zero balances of the account for the
+	// given tokens (or all of them if empty) sorted by token
+	GetBalances(account web3.Address, tokens []web3.Address) ([]*Balance, error)
+
+	// GetBalanceAt returns the balance of the account in the token after
+	// the given block
+	GetBalanceAt(token, account web3.Address, blockNumber uint64) (*Balance, error)
+
+	// GetNFTTransfers returns the ERC721 transfers given a filter
+	GetNFTTransfers(filter TransfersFilter) ([]*NFTTransfer, error)
+
+	// GetNFTOwner returns the current owner of an ERC721 token or
+	// ErrNotFound if the token does not exist or it was burnt
+	GetNFTOwner(token web3.Address, tokenID *big.Int) (*NFTOwner, error)
+
+	// GetMultiTransfers returns the ERC1155 transfers that match the filter
+	GetMultiTransfers(filter TransfersFilter) ([]*MultiTransfer, error)
+
+	// GetMultiBalances returns the non-zero ERC1155 balances of the account
+	// for the given tokens or for all the tokens if none is given, sorted
+	// by token and token id.
+	GetMultiBalances(account web3.Address, tokens []web3.Address) ([]*MultiBalance, error)
+
+	WebhookStore
 }
@@ -1,19 +1,17 @@
 package store
 
 import (
+	"encoding/binary"
 	"math/big"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/umbracle/go-web3"
 	"github.com/umbracle/go-web3/abi"
-	"github.com/umbracle/go-web3/contract/builtin/erc20"
 )
 
-var (
-	transferEvent = erc20.ERC20Abi().Events["Transfer"]
-)
-
-func encodeERC20(r *web3.Receipt, token, from, to web3.Address, balance *big.Int) *web3.Log {
+func encodeERC20(r *web3.Receipt, index uint64, token, from, to web3.Address, balance *big.Int) *web3.Log {
 	encodeTopic := func(t *abi.Argument, i interface{}) web3.Hash {
 		hash, err := abi.EncodeTopic(t.Type, i)
 		if err != nil {
@@ -28,9 +26,12 @@ func encodeERC20(r *web3.Receipt, token, from, to web3.Address, balance *big.Int
 	}
 
 	log := &web3.Log{
-		Address:         token,
-		BlockHash:       r.BlockHash,
-		TransactionHash: r.TransactionHash,
+		Address:          token,
+		BlockHash:        r.BlockHash,
+		BlockNumber:      r.BlockNumber,
+		LogIndex:         index,
+		TransactionHash:  r.TransactionHash,
+		TransactionIndex: r.TransactionIndex,
 		Topics: []web3.Hash{
 			transferEvent.ID(),
 			encodeTopic(transferEvent.Inputs[0], from),
@@ -41,9 +42,81 @@ func encodeERC20(r *web3.Receipt, token, from, to web3.Address, balance *big.Int
 	return log
 }
 
+func encodeERC721(r *web3.Receipt, index uint64, token, from, to web3.Address, tokenID *big.Int) *web3.Log {
+	encodeTopic := func(t *abi.Argument, i interface{}) web3.Hash {
+		hash, err := abi.EncodeTopic(t.Type, i)
+		if err != nil {
+			panic(err)
+		}
+		return hash
+	}
+
+	log := &web3.Log{
+		Address:          token,
+		BlockHash:        r.BlockHash,
+		BlockNumber:      r.BlockNumber,
+		LogIndex:         index,
+		TransactionHash:  r.TransactionHash,
+		TransactionIndex: r.TransactionIndex,
+		Topics: []web3.Hash{
+			nftTransferEvent.ID(),
+			encodeTopic(nftTransferEvent.Inputs[0], from),
+			encodeTopic(nftTransferEvent.Inputs[1], to),
+			encodeTopic(nftTransferEvent.Inputs[2], tokenID),
+		},
+	}
+	return log
+}
+
+func encodeERC1155(r *web3.Receipt, index uint64, token, operator, from, to web3.Address, ids, values []*big.Int) *web3.Log {
+	encodeTopic := func(t *abi.Argument, i interface{}) web3.Hash {
+		hash, err := abi.EncodeTopic(t.Type, i)
+		if err != nil {
+			panic(err)
+		}
+		return hash
+	}
+
+	// a single id is encoded as a TransferSingle event
+	event := transferBatchEvent
+	args := map[string]interface{}{
+		"ids":    ids,
+		"values": values,
+	}
+	if len(ids) == 1 {
+		event = transferSingleEvent
+		args = map[string]interface{}{
+			"id":    ids[0],
+			"value": values[0],
+		}
+	}
+	inputs := event.Inputs[3:]
+	data, err := abi.Encode(args, inputs.Type())
+	if err != nil {
+		panic(err)
+	}
+
+	log := &web3.Log{
+		Address:          token,
+		BlockHash:        r.BlockHash,
+		BlockNumber:      r.BlockNumber,
+		LogIndex:         index,
+		TransactionHash:  r.TransactionHash,
+		TransactionIndex: r.TransactionIndex,
+		Topics: []web3.Hash{
+			event.ID(),
+			encodeTopic(event.Inputs[0], operator),
+			encodeTopic(event.Inputs[1], from),
+			encodeTopic(event.Inputs[2], to),
+		},
+		Data: data,
+	}
+	return log
+}
+
 var (
-	hash1 = web3.HexToHash("0x0000000000000000000000000000000000000001")
-	hash2 = web3.HexToHash("0x0000000000000000000000000000000000000002")
+	hash1 = web3.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000001")
+	hash2 = web3.HexToHash("0x0000000000000000000000000000000000000000000000000000000000000002")
 )
 
 var (
@@ -51,6 +124,7 @@ var (
 	addr2 = web3.HexToAddress("0x0000000000000000000000000000000000000002")
 	addr3 = web3.HexToAddress("0x0000000000000000000000000000000000000003")
 	addr4 = web3.HexToAddress("0x0000000000000000000000000000000000000004")
+	addr5 = web3.HexToAddress("0x0000000000000000000000000000000000000005")
 )
 
 type testFunc func(t *testing.T) (Store, func())
@@ -64,11 +138,11 @@ func testWriteReceipts(t *testing.T, tt testFunc) {
 		TransactionHash: hash2,
 	}
 	logs := []*web3.Log{
-		encodeERC20(r0, addr3, addr1, addr2, big.NewInt(1000)),
-		encodeERC20(r0, addr4, addr2, addr1, big.NewInt(100)),
+		encodeERC20(r0, 0, addr3, addr1, addr2, big.NewInt(1000)),
+		encodeERC20(r0, 1, addr4, addr2, addr1, big.NewInt(100)),
 	}
 
-	if err := store.WriteReceipt(logs); err != nil {
+	if _, err := store.WriteReceipt(logs); err != nil {
 		t.Fatal(err)
 	}
 
@@ -96,12 +170,1225 @@ func testWriteReceipts(t *testing.T, tt testFunc) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(transfers) == 0 {
+	if len(transfers) != 0 {
 		t.Fatal("no transfers expected")
 	}
 }
 
+func testFilterTransfers(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	r0 := &web3.Receipt{
+		BlockHash:       hash1,
+		TransactionHash: hash2,
+	}
+	logs := []*web3.Log{
+		encodeERC20(r0, 0, addr3, addr1, addr2, big.NewInt(1)),
+		encodeERC20(r0, 1, addr3, addr2, addr1, big.NewInt(2)),
+		encodeERC20(r0, 2, addr4, addr1, addr2, big.NewInt(3)),
+		encodeERC20(r0, 3, addr4, addr1, addr3, big.NewInt(4)),
+	}
+	if _, err := store.WriteReceipt(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		filter TransfersFilter
+		count  int
+	}{
+		{TransfersFilter{}, 4},
+		{TransfersFilter{Tokens: []web3.Address{addr3}}, 2},
+		{TransfersFilter{From: []web3.Address{addr1}}, 3},
+		{TransfersFilter{To: []web3.Address{addr2, addr3}}, 3},
+		{TransfersFilter{Tokens: []web3.Address{addr4}, From: []web3.Address{addr1}, To: []web3.Address{addr3}}, 1},
+		{TransfersFilter{Tokens: []web3.Address{addr1}}, 0},
+		{TransfersFilter{QueryPagination: QueryPagination{Limit: 3}}, 3},
+		{TransfersFilter{QueryPagination: QueryPagination{Limit: 3, Offset: 2}}, 2},
+		{TransfersFilter{QueryPagination: QueryPagination{Limit: 3, Offset: 10}}, 0},
+	}
+	for indx, c := range cases {
+		transfers, err := store.GetTokenTransfers(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transfers) != c.count {
+			t.Fatalf("case %d: expected %d transfers but found %d", indx, c.count, len(transfers))
+		}
+	}
+
+	tokens, err := store.ListTokens(QueryPagination{Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 {
+		t.Fatal("1 token expected")
+	}
+}
+
+func testTransferPosition(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	r0 := &web3.Receipt{
+		BlockHash:        hash1,
+		BlockNumber:      10,
+		TransactionHash:  hash2,
+		TransactionIndex: 3,
+	}
+	logs := []*web3.Log{
+		encodeERC20(r0, 5, addr3, addr1, addr2, big.NewInt(1000)),
+		encodeERC20(r0, 6, addr3, addr1, addr2, big.NewInt(1000)),
+	}
+	if _, err := store.WriteReceipt(logs); err != nil {
+		t.Fatal(err)
+	}
+
+	transfers, err := store.GetTokenTransfers(TransfersFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 {
+		t.Fatal("2 transfers expected")
+	}
+	for _, transfer := range transfers {
+		if transfer.BlockHash != hash1.String() || transfer.TxnHash != hash2.String() {
+			t.Fatal("bad hashes")
+		}
+		if transfer.BlockNumber != 10 || transfer.TxnIndex != 3 {
+			t.Fatal("bad position")
+		}
+	}
+	if transfers[0].LogIndex == transfers[1].LogIndex {
+		t.Fatal("transfers should have different log index")
+	}
+}
+
+func testWriteReceiptsIdempotent(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	r0 := &web3.Receipt{
+		BlockHash:       hash1,
+		TransactionHash: hash2,
+	}
+	logs := []*web3.Log{
+		encodeERC20(r0, 0, addr3, addr1, addr2, big.NewInt(1000)),
+		encodeERC20(r0, 1, addr4, addr2, addr1, big.NewInt(100)),
+	}
+
+	// replay the same batch of logs, only the first write returns them
+	for i := 0; i < 2; i++ {
+		written, err := store.WriteReceipt(logs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && len(written) != 2 {
+			t.Fatal("2 logs written expected")
+		}
+		if i == 1 && len(written) != 0 {
+			t.Fatal("no logs written expected")
+		}
+	}
+
+	tokens, err := store.ListTokens(QueryPagination{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatal("2 tokens expected")
+	}
+	transfers, err := store.GetTokenTransfers(TransfersFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 {
+		t.Fatal("2 transfers expected")
+	}
+
+	// the logs can be written again after a reorg
+	if err := store.RemoveReceipts(hash1); err != nil {
+		t.Fatal(err)
+	}
+	if written, err := store.WriteReceipt(logs); err != nil {
+		t.Fatal(err)
+	} else if len(written) != 2 {
+		t.Fatal("2 logs written expected")
+	}
+	transfers, err = store.GetTokenTransfers(TransfersFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 {
+		t.Fatal("2 transfers expected")
+	}
+}
+
+func testBalances(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	getBalances := func(account web3.Address) map[string]string {
+		balances, err := store.GetBalances(account, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := map[string]string{}
+		for _, b := range balances {
+			res[b.Token] = b.Value
+		}
+		return res
+	}
+
+	zero := web3.Address{}
+
+	// mint some tokens
+	r0 := &web3.Receipt{
+		BlockHash:   hash1,
+		BlockNumber: 1,
+	}
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC20(r0, 0, addr3, zero, addr1, big.NewInt(1000)),
+		encodeERC20(r0, 1, addr4, zero, addr2, big.NewInt(50)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	before1, before2 := getBalances(addr1), getBalances(addr2)
+	if !reflect.DeepEqual(before1, map[string]string{addr3.String(): "1000"}) {
+		t.Fatalf("bad balances %v", before1)
+	}
+
+	r1 := &web3.Receipt{
+		BlockHash:   hash2,
+		BlockNumber: 2,
+	}
+	logs := []*web3.Log{
+		encodeERC20(r1, 0, addr3, addr1, addr2, big.NewInt(300)),
+		encodeERC20(r1, 1, addr4, addr2, addr1, big.NewInt(50)),
+	}
+	// replaying the logs does not change the balances
+	for i := 0; i < 2; i++ {
+		if _, err := store.WriteReceipt(logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if b := getBalances(addr1); !reflect.DeepEqual(b, map[string]string{addr3.String(): "700", addr4.String(): "50"}) {
+		t.Fatalf("bad balances %v", b)
+	}
+	if b := getBalances(addr2); !reflect.DeepEqual(b, map[string]string{addr3.String(): "300"}) {
+		t.Fatalf("bad balances %v", b)
+	}
+	if b := getBalances(zero); len(b) != 0 {
+		t.Fatal("the balance of the zero address is not tracked")
+	}
+
+	balances, err := store.GetBalances(addr1, []web3.Address{addr4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Token != addr4.String() || balances[0].Account != addr1.String() {
+		t.Fatal("bad balances filtered by token")
+	}
+
+	// revert the transfers
+	if err := store.RemoveReceipts(hash2); err != nil {
+		t.Fatal(err)
+	}
+	if b := getBalances(addr1); !reflect.DeepEqual(b, before1) {
+		t.Fatalf("bad balances after revert %v", b)
+	}
+	if b := getBalances(addr2); !reflect.DeepEqual(b, before2) {
+		t.Fatalf("bad balances after revert %v", b)
+	}
+}
+
+func blockReceipt(number uint64) *web3.Receipt {
+	r := &web3.Receipt{
+		BlockNumber: number,
+	}
+	binary.BigEndian.PutUint64(r.BlockHash[24:], number)
+	return r
+}
+
+func testBalanceAt(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	zero := web3.Address{}
+	blocks := []struct {
+		number uint64
+		from   web3.Address
+		to     web3.Address
+		value  int64
+	}{
+		{1, zero, addr1, 1000},
+		{500, addr1, addr2, 100},
+		{CheckpointInterval + 500, addr1, addr2, 200},
+		{CheckpointInterval + 600, addr2, addr1, 50},
+	}
+	for _, b := range blocks {
+		r := blockReceipt(b.number)
+		if _, err := store.WriteReceipt([]*web3.Log{
+			encodeERC20(r, 0, addr3, b.from, b.to, big.NewInt(b.value)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBalance := func(account web3.Address, number uint64, expected string) {
+		b, err := store.GetBalanceAt(addr3, account, number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Value != expected {
+			t.Fatalf("expected balance %s at %d but found %s", expected, number, b.Value)
+		}
+	}
+
+	cases := []struct {
+		number uint64
+		value1 string
+		value2 string
+	}{
+		{0, "0", "0"},
+		{1, "1000", "0"},
+		{499, "1000", "0"},
+		{500, "900", "100"},
+		{CheckpointInterval + 499, "900", "100"},
+		{CheckpointInterval + 500, "700", "300"},
+		{CheckpointInterval + 600, "750", "250"},
+		{5 * CheckpointInterval, "750", "250"},
+	}
+	for _, c := range cases {
+		checkBalance(addr1, c.number, c.value1)
+		checkBalance(addr2, c.number, c.value2)
+	}
+
+	// revert the last block and replace it
+	if err := store.RemoveReceipts(blockReceipt(CheckpointInterval + 600).BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(addr1, CheckpointInterval+600, "700")
+
+	r := blockReceipt(CheckpointInterval + 600)
+	r.BlockHash[0] = 1
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC20(r, 0, addr3, addr1, addr2, big.NewInt(10)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance(addr1, CheckpointInterval+600, "690")
+	checkBalance(addr2, CheckpointInterval+600, "310")
+	checkBalance(addr1, CheckpointInterval+500, "700")
+
+	// the latest balance matches the current one
+	balances, err := store.GetBalances(addr1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 1 || balances[0].Value != "690" {
+		t.Fatal("bad current balance")
+	}
+}
+
+func testNFTTransfers(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	zero := web3.Address{}
+	id7, id8 := big.NewInt(7), big.NewInt(8)
+
+	checkOwner := func(tokenID *big.Int, owner web3.Address) {
+		o, err := store.GetNFTOwner(addr3, tokenID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Owner != owner.String() || o.TokenID != tokenID.String() || o.Addr != addr3.String() {
+			t.Fatalf("bad owner %s for token %s", o.Owner, tokenID)
+		}
+	}
+	checkBurnt := func(tokenID *big.Int) {
+		if _, err := store.GetNFTOwner(addr3, tokenID); err != ErrNotFound {
+			t.Fatalf("expected not found but found %v", err)
+		}
+	}
+
+	r1 := blockReceipt(1)
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC721(r1, 0, addr3, zero, addr1, id7),
+		encodeERC721(r1, 1, addr3, zero, addr2, id8),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r2 := blockReceipt(2)
+	logs := []*web3.Log{
+		encodeERC721(r2, 0, addr3, addr1, addr2, id7),
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := store.WriteReceipt(logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkOwner(id7, addr2)
+	checkOwner(id8, addr2)
+	checkBurnt(big.NewInt(9))
+
+	transfers, err := store.GetNFTTransfers(TransfersFilter{Tokens: []web3.Address{addr3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 3 {
+		t.Fatal("3 nft transfers expected")
+	}
+	transfers, err = store.GetNFTTransfers(TransfersFilter{From: []web3.Address{addr1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 || transfers[0].TokenID != "7" || transfers[0].To != addr2.String() || transfers[0].BlockNumber != 2 {
+		t.Fatal("bad nft transfer")
+	}
+
+	// nft transfers are not erc20 transfers
+	erc20Transfers, err := store.GetTokenTransfers(TransfersFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(erc20Transfers) != 0 {
+		t.Fatal("no erc20 transfers expected")
+	}
+	tokens, err := store.ListTokens(QueryPagination{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 {
+		t.Fatal("1 token expected")
+	}
+
+	// burn a token
+	r3 := blockReceipt(3)
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC721(r3, 0, addr3, addr2, zero, id8),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkBurnt(id8)
+
+	// revert the blocks
+	if err := store.RemoveReceipts(r3.BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	checkOwner(id8, addr2)
+
+	if err := store.RemoveReceipts(r2.BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	checkOwner(id7, addr1)
+
+	if err := store.RemoveReceipts(r1.BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	checkBurnt(id7)
+}
+
+func testMultiTransfers(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	zero := web3.Address{}
+	ids := func(vals ...int64) []*big.Int {
+		res := []*big.Int{}
+		for _, v := range vals {
+			res = append(res, big.NewInt(v))
+		}
+		return res
+	}
+
+	checkBalances := func(account web3.Address, expected map[string]string, order ...string) {
+		balances, err := store.GetMultiBalances(account, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(balances) != len(expected) {
+			t.Fatalf("expected %d balances but found %d", len(expected), len(balances))
+		}
+		for indx, b := range balances {
+			if b.Token != addr3.String() || b.Account != account.String() || b.TokenID != order[indx] {
+				t.Fatalf("bad balance %v", b)
+			}
+			if b.Value != expected[b.TokenID] {
+				t.Fatalf("bad balance %s for id %s, expected %s", b.Value, b.TokenID, expected[b.TokenID])
+			}
+		}
+	}
+
+	r1 := blockReceipt(1)
+	malformed := encodeERC1155(r1, 1, addr3, addr1, addr1, addr2, ids(5, 6), ids(1, 1))
+	malformed.Data = malformed.Data[:40]
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC1155(r1, 0, addr3, addr1, zero, addr1, ids(1, 2, 10), ids(10, 20, 30)),
+		malformed,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r2 := blockReceipt(2)
+	logs := []*web3.Log{
+		encodeERC1155(r2, 0, addr3, addr1, addr1, addr2, ids(1), ids(4)),
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := store.WriteReceipt(logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkBalances(addr1, map[string]string{"1": "6", "2": "20", "10": "30"}, "1", "2", "10")
+	checkBalances(addr2, map[string]string{"1": "4"}, "1")
+
+	transfers, err := store.GetMultiTransfers(TransfersFilter{Tokens: []web3.Address{addr3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 4 {
+		t.Fatal("4 multi transfers expected")
+	}
+	for indx, id := range []string{"1", "2", "10"} {
+		if transfers[indx].TokenID != id || transfers[indx].BatchIndex != uint64(indx) {
+			t.Fatal("bad batch transfer")
+		}
+	}
+	transfers, err = store.GetMultiTransfers(TransfersFilter{From: []web3.Address{addr1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 {
+		t.Fatal("1 multi transfer expected")
+	}
+	if tr := transfers[0]; tr.TokenID != "1" || tr.Value != "4" || tr.Operator != addr1.String() || tr.To != addr2.String() || tr.BlockNumber != 2 {
+		t.Fatal("bad multi transfer")
+	}
+
+	// multi transfers are not erc20 transfers
+	erc20Transfers, err := store.GetTokenTransfers(TransfersFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(erc20Transfers) != 0 {
+		t.Fatal("no erc20 transfers expected")
+	}
+
+	// revert the blocks
+	if err := store.RemoveReceipts(r2.BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(addr1, map[string]string{"1": "10", "2": "20", "10": "30"}, "1", "2", "10")
+	checkBalances(addr2, map[string]string{})
+
+	if err := store.RemoveReceipts(r1.BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(addr1, map[string]string{})
+
+	transfers, err = store.GetMultiTransfers(TransfersFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 0 {
+		t.Fatal("no multi transfers expected")
+	}
+}
+
+func testTokenMetadata(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	if _, err := store.GetToken(addr3); err != ErrNotFound {
+		t.Fatalf("expected not found but found %v", err)
+	}
+
+	r1 := blockReceipt(1)
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC20(r1, 0, addr3, addr1, addr2, big.NewInt(1)),
+		encodeERC20(r1, 1, addr4, addr1, addr2, big.NewInt(1)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := store.GetToken(addr3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(token, &Token{Addr: addr3.String()}) {
+		t.Fatal("unresolved token expected")
+	}
+
+	decimals := uint64(18)
+	metadata := []*Token{
+		{
+			Addr:        addr3.String(),
+			Name:        "Token",
+			Symbol:      "TKN",
+			Decimals:    &decimals,
+			TotalSupply: "1000000000000000000000000",
+			Resolved:    true,
+		},
+		{
+			// the token does not implement the metadata
+			Addr:     addr4.String(),
+			Resolved: true,
+		},
+	}
+	for _, m := range metadata {
+		if err := store.WriteTokenMetadata(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, m := range metadata {
+		token, err := store.GetToken(web3.HexToAddress(m.Addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(token, m) {
+			t.Fatalf("bad token metadata %v", token)
+		}
+	}
+
+	tokens, err := store.ListTokens(QueryPagination{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatal("2 tokens expected")
+	}
+	for _, token := range tokens {
+		if !token.Resolved {
+			t.Fatal("resolved token expected")
+		}
+	}
+
+	// writing the token again does not reset the metadata
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC20(blockReceipt(2), 0, addr3, addr2, addr1, big.NewInt(1)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	token, err = store.GetToken(addr3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(token, metadata[0]) {
+		t.Fatalf("bad token metadata %v", token)
+	}
+}
+
+func testTransferRanges(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	zero := web3.Address{}
+	one := big.NewInt(1)
+
+	// the last block is not stored
+	blocks := []*Block{}
+	for i := uint64(1); i <= 4; i++ {
+		r := blockReceipt(i)
+		if i != 4 {
+			blocks = append(blocks, &Block{
+				Hash:      r.BlockHash.String(),
+				Number:    i,
+				Timestamp: i * 100,
+			})
+		}
+	}
+	if err := store.WriteBlocks(blocks); err != nil {
+		t.Fatal(err)
+	}
+	// blocks are written only once
+	if err := store.WriteBlocks(blocks[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(1); i <= 4; i++ {
+		r := blockReceipt(i)
+		if _, err := store.WriteReceipt([]*web3.Log{
+			encodeERC20(r, 0, addr3, addr1, addr2, one),
+			encodeERC721(r, 1, addr4, zero, addr1, big.NewInt(int64(i))),
+			encodeERC1155(r, 2, addr5, addr1, zero, addr1, []*big.Int{one}, []*big.Int{one}),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		filter   TransfersFilter
+		expected []uint64
+	}{
+		{TransfersFilter{}, []uint64{1, 2, 3, 4}},
+		{TransfersFilter{FromBlock: 2}, []uint64{2, 3, 4}},
+		{TransfersFilter{ToBlock: 2}, []uint64{1, 2}},
+		{TransfersFilter{FromBlock: 2, ToBlock: 3}, []uint64{2, 3}},
+		{TransfersFilter{FromTime: 150}, []uint64{2, 3}},
+		{TransfersFilter{FromTime: 150, ToTime: 1000}, []uint64{2, 3}},
+		// the transfers of block 4 have an unknown timestamp
+		{TransfersFilter{ToTime: 200}, []uint64{1, 2}},
+		{TransfersFilter{FromTime: 200, ToTime: 200}, []uint64{2}},
+		{TransfersFilter{FromBlock: 3, ToTime: 200}, []uint64{}},
+	}
+	for _, c := range cases {
+		positions := [][]LogPosition{}
+
+		transfers, err := store.GetTokenTransfers(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := []LogPosition{}
+		for _, t := range transfers {
+			p = append(p, t.LogPosition)
+		}
+		positions = append(positions, p)
+
+		nftTransfers, err := store.GetNFTTransfers(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p = []LogPosition{}
+		for _, t := range nftTransfers {
+			p = append(p, t.LogPosition)
+		}
+		positions = append(positions, p)
+
+		multiTransfers, err := store.GetMultiTransfers(c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p = []LogPosition{}
+		for _, t := range multiTransfers {
+			p = append(p, t.LogPosition)
+		}
+		positions = append(positions, p)
+
+		for _, p := range positions {
+			// the order of the results is not part of the filter
+			sort.Slice(p, func(i, j int) bool {
+				return p[i].BlockNumber < p[j].BlockNumber
+			})
+			if len(p) != len(c.expected) {
+				t.Fatalf("expected %d transfers but found %d", len(c.expected), len(p))
+			}
+			for indx, num := range c.expected {
+				timestamp := num * 100
+				if num == 4 {
+					timestamp = 0
+				}
+				if p[indx].BlockNumber != num || p[indx].Timestamp != timestamp {
+					t.Fatalf("bad transfer at block %d with timestamp %d", p[indx].BlockNumber, p[indx].Timestamp)
+				}
+			}
+		}
+	}
+
+	// the block is removed with its receipts
+	r := blockReceipt(3)
+	if err := store.RemoveReceipts(r.BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.WriteReceipt([]*web3.Log{
+		encodeERC20(r, 0, addr3, addr1, addr2, one),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	transfers, err := store.GetTokenTransfers(TransfersFilter{FromBlock: 3, ToBlock: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 || transfers[0].Timestamp != 0 {
+		t.Fatal("transfer without timestamp expected")
+	}
+}
+
+func testCursorPagination(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	one := big.NewInt(1)
+	writeBlock := func(num uint64) {
+		r := blockReceipt(num)
+		if _, err := store.WriteReceipt([]*web3.Log{
+			encodeERC20(r, 0, addr1, addr1, addr2, one),
+			encodeERC20(r, 1, addr2, addr1, addr2, one),
+			encodeERC1155(r, 2, addr3, addr1, addr1, addr2, []*big.Int{one, big.NewInt(2)}, []*big.Int{one, one}),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(1); i <= 3; i++ {
+		writeBlock(i)
+	}
+
+	// page through the transfers while new blocks are written
+	positions := []*LogCursor{}
+	cursor := ""
+	for {
+		pagination := QueryPagination{Limit: 4, Cursor: cursor}
+		if cursor != "" {
+			// the offset is ignored with a cursor
+			pagination.Offset = 100
+		}
+		transfers, err := store.GetTokenTransfers(TransfersFilter{QueryPagination: pagination})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, t := range transfers {
+			positions = append(positions, &LogCursor{BlockNumber: t.BlockNumber, LogIndex: t.LogIndex})
+		}
+		if len(transfers) < 4 {
+			break
+		}
+		cursor = positions[len(positions)-1].Encode()
+		if len(positions) == 4 {
+			writeBlock(4)
+		}
+	}
+	if len(positions) != 8 {
+		t.Fatalf("expected 8 transfers but found %d", len(positions))
+	}
+	for indx, p := range positions {
+		if p.BlockNumber != uint64(indx/2+1) || p.LogIndex != uint64(indx%2) {
+			t.Fatalf("bad transfer %d at %d:%d", indx, p.BlockNumber, p.LogIndex)
+		}
+	}
+
+	// the cursor of the erc1155 transfers includes the index in the batch
+	multiTransfers, err := store.GetMultiTransfers(TransfersFilter{
+		QueryPagination: QueryPagination{Limit: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := multiTransfers[2]
+	if last.BlockNumber != 2 || last.BatchIndex != 0 {
+		t.Fatal("bad multi transfer")
+	}
+	c := &LogCursor{BlockNumber: last.BlockNumber, LogIndex: last.LogIndex, BatchIndex: last.BatchIndex}
+	multiTransfers, err = store.GetMultiTransfers(TransfersFilter{
+		QueryPagination: QueryPagination{Limit: 3, Cursor: c.Encode()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if multiTransfers[0].BlockNumber != 2 || multiTransfers[0].BatchIndex != 1 {
+		t.Fatal("bad multi transfer after the cursor")
+	}
+
+	// tokens are paginated by address
+	tokens, err := store.ListTokens(QueryPagination{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].Addr != addr1.String() || tokens[1].Addr != addr2.String() {
+		t.Fatal("bad tokens")
+	}
+	tokens, err = store.ListTokens(QueryPagination{Limit: 2, Cursor: EncodeTokenCursor(tokens[1].Addr)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Addr != addr3.String() {
+		t.Fatal("bad tokens after the cursor")
+	}
+
+	if _, err := store.GetTokenTransfers(TransfersFilter{QueryPagination: QueryPagination{Cursor: "bad"}}); err != ErrInvalidCursor {
+		t.Fatalf("expected invalid cursor but found %v", err)
+	}
+	if _, err := store.ListTokens(QueryPagination{Cursor: "bad"}); err != ErrInvalidCursor {
+		t.Fatalf("expected invalid cursor but found %v", err)
+	}
+}
+
+func testTransferOrder(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	values := map[uint64][]int64{
+		1: {10, 9},
+		2: {100, 9},
+		3: {5},
+	}
+	for num := uint64(1); num <= 3; num++ {
+		r := blockReceipt(num)
+		logs := []*web3.Log{}
+		for indx, value := range values[num] {
+			logs = append(logs, encodeERC20(r, uint64(indx), addr1, addr1, addr2, big.NewInt(value)))
+			logs = append(logs, encodeERC721(r, uint64(indx+2), addr2, addr1, addr2, big.NewInt(value)))
+		}
+		logs = append(logs, encodeERC1155(r, 4, addr3, addr1, addr1, addr2, []*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(int64(num) * 10), big.NewInt(int64(num))}))
+		if _, err := store.WriteReceipt(logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// position returns the block and the log index of the transfers
+	position := func(transfers []*Transfer) []uint64 {
+		res := []uint64{}
+		for _, t := range transfers {
+			res = append(res, t.BlockNumber, t.LogIndex)
+		}
+		return res
+	}
+	cases := []struct {
+		sort     SortField
+		desc     bool
+		expected []uint64
+	}{
+		{"", false, []uint64{1, 0, 1, 1, 2, 0, 2, 1, 3, 0}},
+		{SortBlock, true, []uint64{3, 0, 2, 1, 2, 0, 1, 1, 1, 0}},
+		{SortValue, false, []uint64{3, 0, 1, 1, 2, 1, 1, 0, 2, 0}},
+		{SortValue, true, []uint64{2, 0, 1, 0, 2, 1, 1, 1, 3, 0}},
+	}
+	for _, c := range cases {
+		filter := TransfersFilter{Sort: c.sort, Desc: c.desc}
+		transfers, err := store.GetTokenTransfers(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(position(transfers), c.expected) {
+			t.Fatalf("bad order for %s (desc %v): %v", c.sort, c.desc, position(transfers))
+		}
+
+		// paginate with a cursor in the same order
+		found := []*Transfer{}
+		filter.Limit = 2
+		for {
+			page, err := store.GetTokenTransfers(filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found = append(found, page...)
+			if len(page) < 2 {
+				break
+			}
+			last := page[len(page)-1]
+			cursor := &LogCursor{BlockNumber: last.BlockNumber, LogIndex: last.LogIndex}
+			if c.sort == SortValue {
+				cursor.Value = last.Value
+			}
+			filter.Cursor = cursor.Encode()
+		}
+		if !reflect.DeepEqual(position(found), c.expected) {
+			t.Fatalf("bad pages for %s (desc %v): %v", c.sort, c.desc, position(found))
+		}
+	}
+
+	// the nfts are sorted by token id
+	nfts, err := store.GetNFTTransfers(TransfersFilter{Sort: SortValue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, t := range nfts {
+		ids = append(ids, t.TokenID)
+	}
+	if !reflect.DeepEqual(ids, []string{"5", "9", "9", "10", "100"}) {
+		t.Fatalf("bad nft order: %v", ids)
+	}
+
+	multi, err := store.GetMultiTransfers(TransfersFilter{Sort: SortValue, Desc: true, QueryPagination: QueryPagination{Limit: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals := []string{}
+	for _, t := range multi {
+		vals = append(vals, t.Value)
+	}
+	if !reflect.DeepEqual(vals, []string{"30", "20", "10"}) {
+		t.Fatalf("bad multi order: %v", vals)
+	}
+
+	// the cursor of a sort cannot be used with another one
+	cursor := &LogCursor{BlockNumber: 1}
+	if _, err := store.GetTokenTransfers(TransfersFilter{Sort: SortValue, QueryPagination: QueryPagination{Cursor: cursor.Encode()}}); err != ErrInvalidCursor {
+		t.Fatalf("expected invalid cursor but found %v", err)
+	}
+}
+
+// testWebhooks tests the webhooks and their deliveries
+func testWebhooks(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	webhook := &Webhook{
+		ID:            "a",
+		URL:           "http://localhost/a",
+		Secret:        "secret",
+		Tokens:        AddressList{addr1.String(), addr2.String()},
+		Accounts:      AddressList{},
+		Confirmations: 2,
+	}
+	if err := store.CreateWebhook(webhook); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateWebhook(&Webhook{ID: "b", URL: "http://localhost/b", Tokens: AddressList{}, Accounts: AddressList{}}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := store.GetWebhook("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, webhook) {
+		t.Fatal("bad webhook")
+	}
+	if _, err := store.GetWebhook("c"); err != ErrNotFound {
+		t.Fatalf("expected not found but found %v", err)
+	}
+	webhooks, err := store.ListWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 2 || webhooks[0].ID != "a" || webhooks[1].ID != "b" {
+		t.Fatal("bad webhooks")
+	}
+
+	delivery := func(webhookID, hash string, num uint64) *Delivery {
+		return &Delivery{
+			WebhookID:   webhookID,
+			Type:        DeliveryTransfer,
+			BlockHash:   hash,
+			BlockNumber: num,
+			ReadyBlock:  num + 2,
+			Payload:     "{}",
+			Status:      DeliveryPending,
+		}
+	}
+	if err := store.WriteDeliveries([]*Delivery{
+		delivery("a", hash1.String(), 1),
+		delivery("a", hash2.String(), 2),
+		delivery("b", hash2.String(), 2),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the deliveries with enough confirmations are ready
+	pending, err := store.PendingDeliveries(3, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].BlockHash != hash1.String() {
+		t.Fatal("bad pending deliveries")
+	}
+	pending, err = store.PendingDeliveries(4, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].BlockNumber != 1 || pending[1].BlockNumber != 2 {
+		t.Fatal("bad pending deliveries")
+	}
+
+	// a failed attempt is retried later
+	retry := pending[1]
+	retry.Attempts = 1
+	retry.NextAttempt = 20
+	retry.LastError = "failed"
+	if err := store.UpdateDelivery(retry); err != nil {
+		t.Fatal(err)
+	}
+	sent := pending[0]
+	sent.Status = DeliveryDelivered
+	sent.Attempts = 1
+	if err := store.UpdateDelivery(sent); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = store.PendingDeliveries(4, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].WebhookID != "b" {
+		t.Fatal("bad pending deliveries before the retry")
+	}
+	pending, err = store.PendingDeliveries(4, 20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Attempts != 1 || pending[0].LastError != "failed" {
+		t.Fatal("bad pending deliveries after the retry")
+	}
+	if err := store.UpdateDelivery(&Delivery{ID: 1000}); err != ErrNotFound {
+		t.Fatalf("expected not found but found %v", err)
+	}
+
+	// the pending deliveries of a reverted block are removed and the
+	// delivered ones are returned
+	reverted, err := store.RevertDeliveries(hash1.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].ID != sent.ID {
+		t.Fatal("bad reverted deliveries")
+	}
+	reverted, err = store.RevertDeliveries(hash2.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 0 {
+		t.Fatal("expected no reverted deliveries")
+	}
+	pending, err = store.PendingDeliveries(4, 20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatal("expected no pending deliveries")
+	}
+
+	// the deliveries log is sorted from the most recent
+	if err := store.WriteDeliveries([]*Delivery{delivery("a", hash2.String(), 3)}); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := store.ListDeliveries("a", QueryPagination{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].BlockNumber != 3 {
+		t.Fatal("bad deliveries")
+	}
+	deliveries, err = store.ListDeliveries("a", QueryPagination{Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != DeliveryDelivered {
+		t.Fatal("bad deliveries")
+	}
+
+	// the transfers of a block are queued once until they are reversed
+	count := func() int {
+		deliveries, err := store.ListDeliveries("a", QueryPagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(deliveries)
+	}
+	if err := store.WriteDeliveries([]*Delivery{delivery("a", hash2.String(), 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteDeliveries([]*Delivery{delivery("a", hash1.String(), 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if num := count(); num != 2 {
+		t.Fatalf("expected 2 deliveries but found %d", num)
+	}
+	reversal := delivery("a", hash1.String(), 1)
+	reversal.Type = DeliveryReversal
+	if err := store.WriteDeliveries([]*Delivery{reversal, delivery("a", hash1.String(), 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if num := count(); num != 4 {
+		t.Fatalf("expected 4 deliveries but found %d", num)
+	}
+
+	if err := store.DeleteWebhook("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteWebhook("a"); err != ErrNotFound {
+		t.Fatalf("expected not found but found %v", err)
+	}
+	if deliveries, err = store.ListDeliveries("a", QueryPagination{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 0 {
+		t.Fatal("expected no deliveries")
+	}
+}
+
+func testTransferStatus(t *testing.T, tt testFunc) {
+	store, close := tt(t)
+	defer close()
+
+	one := big.NewInt(1)
+	for i := uint64(1); i <= 3; i++ {
+		r := blockReceipt(i)
+		if _, err := store.WriteReceipt([]*web3.Log{
+			encodeERC20(r, 0, addr1, addr1, addr2, one),
+			encodeERC721(r, 1, addr2, addr1, addr2, big.NewInt(int64(i))),
+			encodeERC1155(r, 2, addr3, addr1, addr1, addr2, []*big.Int{one}, []*big.Int{one}),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocks := func(status TransferStatus) []uint64 {
+		filter := TransfersFilter{Status: status}
+		transfers, err := store.GetTokenTransfers(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nftTransfers, err := store.GetNFTTransfers(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		multiTransfers, err := store.GetMultiTransfers(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transfers) != len(nftTransfers) || len(transfers) != len(multiTransfers) {
+			t.Fatal("the transfers have different status")
+		}
+		res := []uint64{}
+		for indx, transfer := range transfers {
+			if nftTransfers[indx].Confirmed != transfer.Confirmed || multiTransfers[indx].Confirmed != transfer.Confirmed {
+				t.Fatal("the transfers have different status")
+			}
+			if status != StatusAll && transfer.Confirmed != (status == StatusConfirmed) {
+				t.Fatal("bad status of the transfer")
+			}
+			res = append(res, transfer.BlockNumber)
+		}
+		return res
+	}
+
+	// the transfers are written as pending
+	if res := blocks(StatusConfirmed); len(res) != 0 {
+		t.Fatal("expected no confirmed transfers")
+	}
+	if res := blocks(StatusPending); !reflect.DeepEqual(res, []uint64{1, 2, 3}) {
+		t.Fatalf("bad pending transfers %v", res)
+	}
+
+	if err := store.ConfirmTransfers(2); err != nil {
+		t.Fatal(err)
+	}
+	if res := blocks(StatusConfirmed); !reflect.DeepEqual(res, []uint64{1, 2}) {
+		t.Fatalf("bad confirmed transfers %v", res)
+	}
+	if res := blocks(StatusPending); !reflect.DeepEqual(res, []uint64{3}) {
+		t.Fatalf("bad pending transfers %v", res)
+	}
+	if res := blocks(StatusAll); !reflect.DeepEqual(res, []uint64{1, 2, 3}) {
+		t.Fatalf("bad transfers %v", res)
+	}
+
+	// a log written again keeps its status
+	r := blockReceipt(1)
+	if _, err := store.WriteReceipt([]*web3.Log{encodeERC20(r, 0, addr1, addr1, addr2, one)}); err != nil {
+		t.Fatal(err)
+	}
+	if res := blocks(StatusConfirmed); !reflect.DeepEqual(res, []uint64{1, 2}) {
+		t.Fatalf("bad confirmed transfers %v", res)
+	}
+}
+
 // TestStore is a generic test function to test different storage methods
 func TestStore(t *testing.T, tt testFunc) {
 	testWriteReceipts(t, tt)
+	testFilterTransfers(t, tt)
+	testTransferPosition(t, tt)
+	testWriteReceiptsIdempotent(t, tt)
+	testBalances(t, tt)
+	testBalanceAt(t, tt)
+	testNFTTransfers(t, tt)
+	testMultiTransfers(t, tt)
+	testTokenMetadata(t, tt)
+	testTransferRanges(t, tt)
+	testCursorPagination(t, tt)
+	testTransferOrder(t, tt)
+	testWebhooks(t, tt)
+	testTransferStatus(t, tt)
 }
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// Delivery types
+const (
+	// DeliveryTransfer notifies the transfers of a block
+	DeliveryTransfer = "transfer"
+
+	// DeliveryReversal notifies that the transfers of a delivered block
+	// were removed by a reorg
+	DeliveryReversal = "reversal"
+)
+
+// Delivery status
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// AddressList is a list of addresses stored as a comma separated string
+type AddressList []string
+
+// Value implements the driver.Valuer interface
+func (a AddressList) Value() (driver.Value, error) {
+	return strings.Join(a, ","), nil
+}
+
+// Scan implements the sql.Scanner interface
+func (a *AddressList) Scan(src interface{}) error {
+	var raw string
+	switch obj := src.(type) {
+	case string:
+		raw = obj
+	case []byte:
+		raw = string(obj)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into an address list", src)
+	}
+	*a = AddressList{}
+	if raw != "" {
+		*a = strings.Split(raw, ",")
+	}
+	return nil
+}
+
+// Webhook is the model for a webhook. The transfers are notified when they
+// have Confirmations blocks on top. Empty Tokens or Accounts match any
+// token or account.
+type Webhook struct {
+	ID            string      `db:"id"`
+	URL           string      `db:"url"`
+	Secret        string      `db:"secret" json:",omitempty"`
+	Tokens        AddressList `db:"tokens"`
+	Accounts      AddressList `db:"accounts"`
+	Confirmations uint64      `db:"confirmations"`
+}
+
+// Delivery is the model for a notification of a webhook. A delivery is
+// ready to be sent once the chain reaches ReadyBlock and NextAttempt (unix
+// time) is reached.
+type Delivery struct {
+	ID          uint64 `db:"id"`
+	WebhookID   string `db:"webhook_id"`
+	Type        string `db:"type"`
+	BlockHash   string `db:"block_hash"`
+	BlockNumber uint64 `db:"block_number"`
+	ReadyBlock  uint64 `db:"ready_block"`
+	Payload     string `db:"payload"`
+	Status      string `db:"status"`
+	Attempts    uint64 `db:"attempts"`
+	NextAttempt uint64 `db:"next_attempt"`
+	LastError   string `db:"last_error"`
+}
+
+// WebhookStore is the interface to store the webhooks and their deliveries
+type WebhookStore interface {
+	// CreateWebhook stores a new webhook
+	CreateWebhook(w *Webhook) error
+
+	// GetWebhook returns a webhook or ErrNotFound
+	GetWebhook(id string) (*Webhook, error)
+
+	// ListWebhooks returns all the webhooks
+	ListWebhooks() ([]*Webhook, error)
+
+	// DeleteWebhook removes a webhook and its deliveries or returns
+	// ErrNotFound
+	DeleteWebhook(id string) error
+
+	// WriteDeliveries stores new pending deliveries. A transfer delivery
+	// is skipped if the webhook has a transfer delivery of the same block
+	// that was not reversed, so the transfers of a block are queued once
+	// even if they are notified again.
+	WriteDeliveries(deliveries []*Delivery) error
+
+	// PendingDeliveries returns the pending deliveries that are ready at
+	// the head block and time now, in the order they were written
+	PendingDeliveries(head, now uint64, limit int) ([]*Delivery, error)
+
+	// UpdateDelivery updates the status, the attempts, the next attempt
+	// and the last error of a delivery
+	UpdateDelivery(d *Delivery) error
+
+	// RevertDeliveries removes the pending transfer deliveries of a block
+	// and returns the delivered ones to notify their reversal
+	RevertDeliveries(blockHash string) ([]*Delivery, error)
+
+	// ListDeliveries returns the deliveries of a webhook, the most recent
+	// first
+	ListDeliveries(webhookID string, p QueryPagination) ([]*Delivery, error)
+}
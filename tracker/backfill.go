@@ -0,0 +1,339 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+// backfillDistance is the number of blocks below the head that are not
+// backfilled. They are synced in order by the tracker, that handles the
+// reorgs.
+const backfillDistance = 128
+
+// backfillKey is the key of the last backfilled block in the store of the
+// go-web3 tracker. The backfill checkpoints each shard with it.
+var backfillKey = []byte("tokenTrackerBackfill")
+
+// trackerLastBlockKey is the key of the last synced block of the go-web3
+// tracker. The pinned go-web3 version cannot be configured with a start
+// block, the tracker is resumed after the backfill by setting its last
+// block. The handoff is checked with the public GetLastBlock, so a go-web3
+// upgrade that changes the key or the encoding fails the sync instead of
+// syncing again from the genesis.
+var trackerLastBlockKey = []byte("lastBlock")
+
+// shard is a range of blocks fetched by a backfill worker
+type shard struct {
+	from, to uint64
+
+	logs   []*web3.Log
+	blocks []*store.Block
+	err    error
+
+	// done is closed once the shard is fetched
+	done chan struct{}
+}
+
+// splitShards splits the inclusive range of blocks in shards of size blocks
+func splitShards(from, to, size uint64) []*shard {
+	shards := []*shard{}
+	for i := from; i <= to; i += size {
+		end := i + size - 1
+		if end > to || end < i {
+			end = to
+		}
+		shards = append(shards, &shard{from: i, to: end, done: make(chan struct{})})
+		if end == to {
+			break
+		}
+	}
+	return shards
+}
+
+// getBlock returns a block stored with key or nil if it is not set
+func (t *TokenTracker) getBlock(key []byte) (*web3.Block, error) {
+	buf, err := t.trackerStore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	b := &web3.Block{}
+	if err := b.UnmarshalJSON(buf); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// setBlock stores a block with key
+func (t *TokenTracker) setBlock(key []byte, b *web3.Block) error {
+	if b.Difficulty == nil {
+		b.Difficulty = big.NewInt(0)
+	}
+	buf, err := b.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return t.trackerStore.Set(key, buf)
+}
+
+// lastBlock returns the last block synced either by the backfill or by the
+// tracker or nil if no block was synced yet
+func (t *TokenTracker) lastBlock() (*web3.Block, error) {
+	last, err := t.getBlock(backfillKey)
+	if err != nil {
+		return nil, err
+	}
+	synced, err := t.newTracker(nil).GetLastBlock()
+	if err != nil {
+		return nil, err
+	}
+	if last == nil || synced != nil && synced.Number > last.Number {
+		return synced, nil
+	}
+	return last, nil
+}
+
+// resumeTracker makes the go-web3 tracker resume after the last backfilled
+// block if it is behind it
+func (t *TokenTracker) resumeTracker() error {
+	last, err := t.getBlock(backfillKey)
+	if err != nil {
+		return err
+	}
+	if last == nil {
+		return nil
+	}
+	tr := t.newTracker(nil)
+	synced, err := tr.GetLastBlock()
+	if err != nil {
+		return err
+	}
+	if synced != nil && synced.Number >= last.Number {
+		return nil
+	}
+	if err := t.setBlock(trackerLastBlockKey, last); err != nil {
+		return err
+	}
+	if synced, err = tr.GetLastBlock(); err != nil {
+		return err
+	}
+	if synced == nil || synced.Number != last.Number || synced.Hash != last.Hash {
+		return fmt.Errorf("the tracker does not resume after the backfilled block %d", last.Number)
+	}
+	return nil
+}
+
+// rewind makes the go-web3 tracker sync again the blocks from the block
+// from. Its logs of these blocks are removed, they are stored again once
+// synced.
+func (t *TokenTracker) rewind(from uint64) error {
+	index, err := t.trackerStore.LastIndex()
+	if err != nil {
+		return err
+	}
+	for ; index != 0; index-- {
+		var log web3.Log
+		if err := t.trackerStore.GetLog(index-1, &log); err != nil {
+			return err
+		}
+		if log.BlockNumber < from {
+			break
+		}
+	}
+	if err := t.trackerStore.RemoveLogs(index); err != nil {
+		return err
+	}
+
+	if from == 0 {
+		// the tracker syncs again from the genesis without a last block
+		return t.trackerStore.Set(trackerLastBlockKey, []byte{})
+	}
+	block, err := t.provider.GetBlockByNumber(web3.BlockNumber(from-1), false)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return &blockNotFoundError{block: strconv.FormatUint(from-1, 10)}
+	}
+	if err := t.setBlock(trackerLastBlockKey, block); err != nil {
+		return err
+	}
+	t.logger.Printf("[INFO] Rewound the tracker to block %d", block.Number)
+	return nil
+}
+
+// backfill syncs the historical blocks up to backfillDistance blocks below
+// the head before the tracker starts. The range is split in shards of
+// ShardSize blocks that are fetched by Workers workers in parallel and
+// written to the store in block order. Each shard written is checkpointed.
+// The backfill is disabled with less than two workers.
+func (t *TokenTracker) backfill(ctx context.Context) error {
+	if t.config.Workers < 2 {
+		return t.resumeTracker()
+	}
+	head, err := t.provider.BlockNumber()
+	if err != nil {
+		return err
+	}
+	last, err := t.lastBlock()
+	if err != nil {
+		return err
+	}
+	from := uint64(0)
+	if last != nil {
+		from = last.Number + 1
+	}
+	if head < backfillDistance || from > head-backfillDistance {
+		return t.resumeTracker()
+	}
+	to := head - backfillDistance
+
+	shardSize := t.config.ShardSize
+	if shardSize == 0 {
+		shardSize = DefaultConfig().ShardSize
+	}
+	shards := splitShards(from, to, shardSize)
+	t.logger.Printf("[INFO] Backfilling blocks %d to %d in %d shards with %d workers", from, to, len(shards), t.config.Workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// the shards are fetched in order and at most window of them wait to
+	// be written
+	window := make(chan struct{}, 2*t.config.Workers)
+	jobs := make(chan *shard)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+
+		for _, s := range shards {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < t.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for s := range jobs {
+				s.logs, s.blocks, s.err = t.fetchShard(ctx, s)
+				close(s.done)
+			}
+		}()
+	}
+
+	for _, s := range shards {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if s.err != nil {
+			return s.err
+		}
+		if err := t.writeShard(s); err != nil {
+			return err
+		}
+		<-window
+	}
+	return t.resumeTracker()
+}
+
+// fetchShard queries the logs of the shard in windows of BatchSize blocks
+// and the blocks that timestamp them
+func (t *TokenTracker) fetchShard(ctx context.Context, s *shard) ([]*web3.Log, []*store.Block, error) {
+	batchSize := uint64(t.config.BatchSize)
+	if batchSize == 0 {
+		batchSize = 1
+	}
+
+	logs := []*web3.Log{}
+	for i := s.from; i <= s.to; i += batchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		dst := i + batchSize - 1
+		if dst > s.to || dst < i {
+			dst = s.to
+		}
+
+		filter := &web3.LogFilter{}
+		filter.SetFromUint64(i)
+		filter.SetToUint64(dst)
+
+		res, err := t.provider.GetLogs(filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		logs = append(logs, res...)
+
+		if dst == s.to {
+			break
+		}
+	}
+
+	blocks, err := t.blocks.logBlocks(logs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logs, blocks, nil
+}
+
+// writeShard writes the logs of a shard and checkpoints it
+func (t *TokenTracker) writeShard(s *shard) error {
+	if len(s.logs) != 0 {
+		if err := t.writeLogs(s.logs, s.blocks); err != nil {
+			return err
+		}
+		// the tracker removes these logs if the last block is reorged
+		// when it starts
+		if err := t.trackerStore.StoreLogs(s.logs); err != nil {
+			return err
+		}
+	}
+
+	block, err := t.provider.GetBlockByNumber(web3.BlockNumber(s.to), false)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return &blockNotFoundError{block: strconv.FormatUint(s.to, 10)}
+	}
+	if err := t.setBlock(backfillKey, block); err != nil {
+		return err
+	}
+
+	t.status.indexed(s.to)
+	if t.syncCh != nil {
+		select {
+		case t.syncCh <- s.to:
+		default:
+		}
+	}
+	return t.confirm()
+}
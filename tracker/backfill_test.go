@@ -0,0 +1,404 @@
+package tracker
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/tracker"
+)
+
+// erc20Topic is the topic of the erc20 Transfer event
+var erc20Topic = web3.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+// mockChain is a json-rpc server of a chain with an erc20 transfer in every
+// block and tokens without metadata. It records the ranges of the
+// eth_getLogs queries. The blocks by number in missing are not found.
+type mockChain struct {
+	t       *testing.T
+	head    uint64
+	missing map[uint64]bool
+
+	lock   sync.Mutex
+	ranges [][2]uint64
+}
+
+func chainBlock(num uint64) *web3.Block {
+	b := &web3.Block{
+		Number:     num,
+		Timestamp:  num * 10,
+		Difficulty: big.NewInt(1),
+	}
+	binary.BigEndian.PutUint64(b.Hash[24:], num+1)
+	binary.BigEndian.PutUint64(b.ParentHash[24:], num)
+	return b
+}
+
+func chainLog(num uint64) *web3.Log {
+	b := chainBlock(num)
+
+	var from, to web3.Hash
+	from[31] = 0x1
+	to[31] = 0x2
+	data := make([]byte, 32)
+	data[31] = 0x1
+
+	return &web3.Log{
+		Address:     web3.Address{0x1},
+		BlockHash:   b.Hash,
+		BlockNumber: num,
+		Topics:      []web3.Hash{erc20Topic, from, to},
+		Data:        data,
+	}
+}
+
+func parseHex(t *testing.T, raw string) uint64 {
+	num, err := strconv.ParseUint(strings.TrimPrefix(raw, "0x"), 16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return num
+}
+
+func (m *mockChain) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	var req struct {
+		ID     uint64
+		Method string
+		Params []json.RawMessage
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		m.t.Fatal(err)
+	}
+
+	var result interface{}
+	switch req.Method {
+	case "eth_blockNumber":
+		m.lock.Lock()
+		result = fmt.Sprintf("0x%x", m.head)
+		m.lock.Unlock()
+
+	case "eth_chainId":
+		result = "0x1"
+
+	case "eth_call":
+		// the tokens have no metadata
+		result = "0x"
+
+	case "eth_getBlockByNumber", "eth_getBlockByHash":
+		var raw string
+		if err := json.Unmarshal(req.Params[0], &raw); err != nil {
+			m.t.Fatal(err)
+		}
+		var num uint64
+		if req.Method == "eth_getBlockByHash" {
+			hash := web3.HexToHash(raw)
+			num = binary.BigEndian.Uint64(hash[24:]) - 1
+		} else if raw == "latest" {
+			m.lock.Lock()
+			num = m.head
+			m.lock.Unlock()
+		} else {
+			num = parseHex(m.t, raw)
+			if m.missing[num] {
+				break
+			}
+		}
+		buf, err := chainBlock(num).MarshalJSON()
+		if err != nil {
+			m.t.Fatal(err)
+		}
+		result = json.RawMessage(buf)
+
+	case "eth_getLogs":
+		var filter logFilter
+		if err := json.Unmarshal(req.Params[0], &filter); err != nil {
+			m.t.Fatal(err)
+		}
+		var from, to uint64
+		if filter.BlockHash != nil {
+			from = binary.BigEndian.Uint64(filter.BlockHash[24:]) - 1
+			to = from
+		} else {
+			from, to = parseHex(m.t, filter.FromBlock), parseHex(m.t, filter.ToBlock)
+
+			m.lock.Lock()
+			m.ranges = append(m.ranges, [2]uint64{from, to})
+			m.lock.Unlock()
+		}
+
+		logs := []json.RawMessage{}
+		for i := from; i <= to; i++ {
+			buf, err := chainLog(i).MarshalJSON()
+			if err != nil {
+				m.t.Fatal(err)
+			}
+			logs = append(logs, buf)
+		}
+		result = logs
+
+	default:
+		m.t.Fatalf("unexpected method %s", req.Method)
+	}
+
+	resp := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      req.ID,
+		"result":  result,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		m.t.Fatal(err)
+	}
+}
+
+// orderStore fails if the receipts are not written in block order
+type orderStore struct {
+	*memory.Store
+	t    *testing.T
+	last uint64
+}
+
+func (o *orderStore) WriteReceipt(logs []*web3.Log) ([]*web3.Log, error) {
+	for _, log := range logs {
+		if log.BlockNumber < o.last {
+			o.t.Fatalf("block %d written after %d", log.BlockNumber, o.last)
+		}
+		o.last = log.BlockNumber
+	}
+	return o.Store.WriteReceipt(logs)
+}
+
+func newBackfillTracker(t *testing.T, url string, s Store, trackerStore tracker.Store) *TokenTracker {
+	logger := log.New(ioutil.Discard, "", 0)
+
+	trackerConfig := tracker.DefaultConfig()
+	trackerConfig.BatchSize = 3
+
+	client, err := newFailoverClient(logger, []*EndpointConfig{{URL: url}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := &TokenTracker{
+		logger: logger,
+		store:  s,
+		config: &Config{
+			BatchSize: 3,
+			Workers:   4,
+			ShardSize: 10,
+		},
+		client:        client,
+		provider:      newProvider(client, store.Topics(), newBatchSizer(logger, 3)),
+		resolver:      NewResolver(logger, client, s),
+		blocks:        newBlockCache(newEth(client)),
+		status:        &syncStatus{},
+		broker:        NewBroker(),
+		trackerStore:  trackerStore,
+		trackerConfig: trackerConfig,
+		retryDelay: func(uint64) time.Duration {
+			return time.Millisecond
+		},
+		headInterval: headCheckInterval,
+	}
+	tt.notifier = NewNotifier(logger, s, func() uint64 { return 0 }, true)
+	return tt
+}
+
+func TestSplitShards(t *testing.T) {
+	shards := splitShards(5, 30, 10)
+	if len(shards) != 3 {
+		t.Fatalf("expected 3 shards but found %d", len(shards))
+	}
+	expected := [][2]uint64{{5, 14}, {15, 24}, {25, 30}}
+	for indx, s := range shards {
+		if s.from != expected[indx][0] || s.to != expected[indx][1] {
+			t.Fatalf("bad shard %d: %d-%d", indx, s.from, s.to)
+		}
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	chain := &mockChain{t: t, head: 200}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	s := &orderStore{Store: memory.New(), t: t}
+	trackerStore := tracker.NewInmemStore()
+
+	tt := newBackfillTracker(t, srv.URL, s, trackerStore)
+	if err := tt.backfill(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the blocks up to backfillDistance below the head are written
+	transfers, err := s.GetTokenTransfers(store.TransfersFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 73 {
+		t.Fatalf("expected 73 transfers but found %d", len(transfers))
+	}
+	for indx, transfer := range transfers {
+		if transfer.BlockNumber != uint64(indx) || transfer.Timestamp != uint64(indx)*10 {
+			t.Fatalf("bad transfer %d", indx)
+		}
+	}
+	last, err := tt.lastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.Number != 72 || last.Hash != chainBlock(72).Hash {
+		t.Fatal("bad checkpoint")
+	}
+	// the go-web3 tracker resumes after the backfilled blocks
+	if synced, err := tt.newTracker(nil).GetLastBlock(); err != nil || synced == nil || synced.Number != 72 {
+		t.Fatal("the tracker does not resume after the backfill")
+	}
+	if status := tt.Status(); status.LastBlock != 72 {
+		t.Fatalf("bad status %v", status)
+	}
+
+	// the backfill resumes after the checkpoint
+	chain.lock.Lock()
+	chain.head = 250
+	chain.ranges = nil
+	chain.lock.Unlock()
+
+	tt = newBackfillTracker(t, srv.URL, s, trackerStore)
+	if err := tt.backfill(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if transfers, err = s.GetTokenTransfers(store.TransfersFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 123 {
+		t.Fatalf("expected 123 transfers but found %d", len(transfers))
+	}
+	for _, r := range chain.ranges {
+		if r[0] <= 72 || r[1] > 122 {
+			t.Fatalf("bad range %d-%d", r[0], r[1])
+		}
+	}
+}
+
+func TestWriteShardUnknownBlock(t *testing.T) {
+	chain := &mockChain{t: t, head: 200, missing: map[uint64]bool{9: true}}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	tt := newBackfillTracker(t, srv.URL, memory.New(), tracker.NewInmemStore())
+
+	// the shard is retried if the endpoint does not know its last block
+	err := tt.writeShard(&shard{from: 0, to: 9})
+	if err == nil || !isTransient(err) {
+		t.Fatalf("expected a transient error but found %v", err)
+	}
+	if last, err := tt.getBlock(backfillKey); err != nil || last != nil {
+		t.Fatal("the shard is checkpointed")
+	}
+}
+
+func TestBackfillDisabled(t *testing.T) {
+	chain := &mockChain{t: t, head: 200}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	tt := newBackfillTracker(t, srv.URL, memory.New(), tracker.NewInmemStore())
+	tt.config.Workers = 1
+	if err := tt.backfill(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.ranges) != 0 {
+		t.Fatal("the backfill is disabled with one worker")
+	}
+}
+
+// readOnlyStore ignores the writes of any key but the backfill checkpoint
+type readOnlyStore struct {
+	tracker.Store
+}
+
+func (r *readOnlyStore) Set(k, v []byte) error {
+	if string(k) != string(backfillKey) {
+		return nil
+	}
+	return r.Store.Set(k, v)
+}
+
+func TestBackfillResumeFails(t *testing.T) {
+	chain := &mockChain{t: t, head: 200}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	// the tracker cannot resume after the backfill
+	tt := newBackfillTracker(t, srv.URL, memory.New(), &readOnlyStore{tracker.NewInmemStore()})
+	if err := tt.backfill(context.Background()); err == nil {
+		t.Fatal("expected the backfill to fail")
+	}
+}
+
+func TestRewind(t *testing.T) {
+	chain := &mockChain{t: t, head: 200}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	trackerStore := tracker.NewInmemStore()
+	tt := newBackfillTracker(t, srv.URL, memory.New(), trackerStore)
+
+	logs := []*web3.Log{}
+	for i := uint64(1); i <= 5; i++ {
+		logs = append(logs, chainLog(i))
+	}
+	if err := trackerStore.StoreLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+	if err := tt.setBlock(trackerLastBlockKey, chainBlock(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tracker syncs again the blocks from 3
+	if err := tt.rewind(3); err != nil {
+		t.Fatal(err)
+	}
+	last, err := tt.newTracker(nil).GetLastBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.Number != 2 || last.Hash != chainBlock(2).Hash {
+		t.Fatalf("expected the last block 2 but found %v", last)
+	}
+	index, err := trackerStore.LastIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 2 {
+		t.Fatalf("expected 2 logs but found %d", index)
+	}
+
+	// the tracker syncs again from the genesis
+	if err := tt.rewind(0); err != nil {
+		t.Fatal(err)
+	}
+	if last, err = tt.newTracker(nil).GetLastBlock(); err != nil || last != nil {
+		t.Fatalf("expected no last block but found %v", last)
+	}
+	if index, err = trackerStore.LastIndex(); err != nil || index != 0 {
+		t.Fatalf("expected no logs but found %d", index)
+	}
+}
@@ -0,0 +1,109 @@
+package tracker
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// batchSmallResults is the number of logs below which a response is small
+// and the batch size grows
+const batchSmallResults = 1000
+
+// batchErrors are the messages of the provider errors for a range of blocks
+// that returns too many results or takes too long to query
+var batchErrors = []string{
+	"query returned more than",
+	"timeout",
+	"timed out",
+}
+
+// isBatchError returns true if the query of a smaller range of blocks
+// may succeed
+func isBatchError(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, e := range batchErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// batchSizer is the number of blocks queried at once with eth_getLogs. It
+// halves when the provider rejects a range and doubles up to max when the
+// responses are small. It is safe for concurrent use.
+type batchSizer struct {
+	logger *log.Logger
+
+	lock sync.Mutex
+	size uint64
+	max  uint64
+}
+
+func newBatchSizer(logger *log.Logger, max uint64) *batchSizer {
+	if max == 0 {
+		max = 1
+	}
+	b := &batchSizer{
+		logger: logger,
+		size:   max,
+		max:    max,
+	}
+	metricBatchSize.Set(float64(max))
+	return b
+}
+
+// get returns the current batch size
+func (b *batchSizer) get() uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.size
+}
+
+// shrink halves the batch size after a query of size blocks failed. It
+// returns false if the size cannot shrink anymore.
+func (b *batchSizer) shrink(size uint64) bool {
+	if size <= 1 {
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// concurrent queries may have already shrunk the size
+	if size/2 < b.size {
+		b.set(size / 2)
+	}
+	return true
+}
+
+// grow doubles the batch size after a query of size blocks returned
+// count logs
+func (b *batchSizer) grow(size uint64, count int) {
+	if count >= batchSmallResults {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// only a query of the current size is a hint to grow
+	if size != b.size || b.size == b.max {
+		return
+	}
+	next := b.size * 2
+	if next > b.max || next < b.size {
+		next = b.max
+	}
+	b.set(next)
+}
+
+func (b *batchSizer) set(size uint64) {
+	b.size = size
+	metricBatchSize.Set(float64(size))
+	b.logger.Printf("[INFO] Batch size for eth_getLogs is %d blocks", size)
+}
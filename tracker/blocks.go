@@ -0,0 +1,110 @@
+package tracker
+
+import (
+	"sync"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+// blockCacheSize is the number of blocks kept in the cache
+const blockCacheSize = 1024
+
+type blockProvider interface {
+	GetBlockByHash(hash web3.Hash, full bool) (*web3.Block, error)
+}
+
+// blockCache caches the blocks of the logs. The blocks that are not in the
+// cache are queried from the provider. It is safe for concurrent use.
+type blockCache struct {
+	provider blockProvider
+
+	lock   sync.Mutex
+	blocks map[web3.Hash]*store.Block
+	order  []web3.Hash
+}
+
+func newBlockCache(provider blockProvider) *blockCache {
+	return &blockCache{
+		provider: provider,
+		blocks:   map[web3.Hash]*store.Block{},
+		order:    []web3.Hash{},
+	}
+}
+
+// add adds a block to the cache evicting the oldest one if it is full
+func (c *blockCache) add(b *web3.Block) *store.Block {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if block, ok := c.blocks[b.Hash]; ok {
+		return block
+	}
+	if len(c.order) == blockCacheSize {
+		delete(c.blocks, c.order[0])
+		c.order = c.order[1:]
+	}
+	block := &store.Block{
+		Hash:      b.Hash.String(),
+		Number:    b.Number,
+		Timestamp: b.Timestamp,
+	}
+	c.blocks[b.Hash] = block
+	c.order = append(c.order, b.Hash)
+	return block
+}
+
+// remove removes a block from the cache
+func (c *blockCache) remove(hash web3.Hash) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.blocks[hash]; !ok {
+		return
+	}
+	delete(c.blocks, hash)
+	for indx, h := range c.order {
+		if h == hash {
+			c.order = append(c.order[:indx], c.order[indx+1:]...)
+			break
+		}
+	}
+}
+
+// get returns a block by hash. The lock is not held while the block is
+// queried.
+func (c *blockCache) get(hash web3.Hash) (*store.Block, error) {
+	c.lock.Lock()
+	b, ok := c.blocks[hash]
+	c.lock.Unlock()
+	if ok {
+		return b, nil
+	}
+	block, err := c.provider.GetBlockByHash(hash, false)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, &blockNotFoundError{block: hash.String()}
+	}
+	return c.add(block), nil
+}
+
+// logBlocks returns the blocks of the logs
+func (c *blockCache) logBlocks(logs []*web3.Log) ([]*store.Block, error) {
+	blocks := []*store.Block{}
+	seen := map[web3.Hash]struct{}{}
+	for _, log := range logs {
+		if _, ok := seen[log.BlockHash]; ok {
+			continue
+		}
+		seen[log.BlockHash] = struct{}{}
+
+		b, err := c.get(log.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
@@ -0,0 +1,98 @@
+package tracker
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/umbracle/go-web3"
+)
+
+type mockBlockProvider struct {
+	calls int
+}
+
+func (m *mockBlockProvider) GetBlockByHash(hash web3.Hash, full bool) (*web3.Block, error) {
+	m.calls++
+	if hash == (web3.Hash{}) {
+		return nil, nil
+	}
+	num := binary.BigEndian.Uint64(hash[24:])
+	b := &web3.Block{
+		Hash:      hash,
+		Number:    num,
+		Timestamp: num * 10,
+	}
+	return b, nil
+}
+
+func mockBlockHash(num uint64) web3.Hash {
+	var hash web3.Hash
+	binary.BigEndian.PutUint64(hash[24:], num)
+	return hash
+}
+
+func TestBlockCache(t *testing.T) {
+	provider := &mockBlockProvider{}
+	c := newBlockCache(provider)
+
+	// blocks from the events are not queried
+	c.add(&web3.Block{Hash: mockBlockHash(1), Number: 1, Timestamp: 10})
+
+	logs := []*web3.Log{
+		{BlockHash: mockBlockHash(1)},
+		{BlockHash: mockBlockHash(2)},
+		{BlockHash: mockBlockHash(2)},
+		{BlockHash: mockBlockHash(3)},
+	}
+	blocks, err := c.logBlocks(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatal("3 blocks expected")
+	}
+	for indx, b := range blocks {
+		num := uint64(indx + 1)
+		if b.Number != num || b.Timestamp != num*10 || b.Hash != mockBlockHash(num).String() {
+			t.Fatalf("bad block %v", b)
+		}
+	}
+	if provider.calls != 2 {
+		t.Fatalf("2 calls expected but found %d", provider.calls)
+	}
+
+	// cached blocks are not queried again
+	if _, err := c.logBlocks(logs); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 2 {
+		t.Fatalf("2 calls expected but found %d", provider.calls)
+	}
+
+	// removed blocks are queried again
+	c.remove(mockBlockHash(2))
+	if _, err := c.logBlocks(logs); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 3 {
+		t.Fatalf("3 calls expected but found %d", provider.calls)
+	}
+
+	if _, err := c.get(web3.Hash{}); err == nil {
+		t.Fatal("unknown block should fail")
+	}
+}
+
+func TestBlockCacheEviction(t *testing.T) {
+	c := newBlockCache(&mockBlockProvider{})
+
+	for i := uint64(1); i <= blockCacheSize+1; i++ {
+		c.add(&web3.Block{Hash: mockBlockHash(i), Number: i})
+	}
+	if len(c.blocks) != blockCacheSize || len(c.order) != blockCacheSize {
+		t.Fatal("the cache is full")
+	}
+	if _, ok := c.blocks[mockBlockHash(1)]; ok {
+		t.Fatal("the oldest block should be evicted")
+	}
+}
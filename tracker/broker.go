@@ -0,0 +1,234 @@
+package tracker
+
+import (
+	"sync"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+// subscriptionBuffer is the number of events buffered for each subscriber.
+// A subscriber that falls behind by more events is dropped.
+const subscriptionBuffer = 256
+
+// EventType is the type of a transfers event
+type EventType string
+
+const (
+	// EventAdded is emitted when new transfers are written in the store
+	EventAdded EventType = "added"
+
+	// EventRemoved is emitted when the transfers of a block are removed
+	// from the store by a reorg
+	EventRemoved EventType = "removed"
+)
+
+// Event is a change in the transfers of the store. The added events include
+// the transfers and the removed events the hash and number of the reverted
+// block.
+type Event struct {
+	Type EventType
+
+	Transfers      []*store.Transfer      `json:",omitempty"`
+	NFTTransfers   []*store.NFTTransfer   `json:",omitempty"`
+	MultiTransfers []*store.MultiTransfer `json:",omitempty"`
+
+	BlockHash   string `json:",omitempty"`
+	BlockNumber uint64 `json:",omitempty"`
+}
+
+// newAddedEvent decodes the transfers of the logs with the timestamps of
+// their blocks
+func newAddedEvent(logs []*web3.Log, blocks []*store.Block) *Event {
+	timestamps := map[string]uint64{}
+	for _, b := range blocks {
+		timestamps[b.Hash] = b.Timestamp
+	}
+
+	evnt := &Event{
+		Type: EventAdded,
+	}
+	for _, log := range logs {
+		timestamp := timestamps[log.BlockHash.String()]
+
+		if t, _ := store.ParseTransfer(log); t != nil {
+			t.Timestamp = timestamp
+			evnt.Transfers = append(evnt.Transfers, t)
+		} else if t, _ := store.ParseNFTTransfer(log); t != nil {
+			t.Timestamp = timestamp
+			evnt.NFTTransfers = append(evnt.NFTTransfers, t)
+		} else if transfers, _ := store.ParseMultiTransfers(log); transfers != nil {
+			for _, t := range transfers {
+				t.Timestamp = timestamp
+			}
+			evnt.MultiTransfers = append(evnt.MultiTransfers, transfers...)
+		}
+	}
+	return evnt
+}
+
+// Filter selects the transfers of a subscription. An empty list matches
+// any address. Accounts matches the transfers from or to any of the
+// accounts.
+type Filter struct {
+	Tokens   []web3.Address
+	From     []web3.Address
+	To       []web3.Address
+	Accounts []web3.Address
+}
+
+func addressSet(addrs []web3.Address) map[string]struct{} {
+	res := map[string]struct{}{}
+	for _, addr := range addrs {
+		res[addr.String()] = struct{}{}
+	}
+	return res
+}
+
+// filterSet is a filter with the addresses indexed
+type filterSet struct {
+	tokens, from, to, accounts map[string]struct{}
+}
+
+func newFilterSet(f Filter) *filterSet {
+	return &filterSet{
+		tokens:   addressSet(f.Tokens),
+		from:     addressSet(f.From),
+		to:       addressSet(f.To),
+		accounts: addressSet(f.Accounts),
+	}
+}
+
+func (f *filterSet) match(token, from, to string) bool {
+	contains := func(set map[string]struct{}, addr string) bool {
+		if len(set) == 0 {
+			return true
+		}
+		_, ok := set[addr]
+		return ok
+	}
+	return contains(f.tokens, token) && contains(f.from, from) && contains(f.to, to) &&
+		(contains(f.accounts, from) || contains(f.accounts, to))
+}
+
+// apply returns the event with the transfers that match the filter or nil
+// if there are none. The removed events always match.
+func (f *filterSet) apply(evnt *Event) *Event {
+	if evnt.Type != EventAdded {
+		return evnt
+	}
+	res := &Event{
+		Type: evnt.Type,
+	}
+	for _, t := range evnt.Transfers {
+		if f.match(t.Addr, t.From, t.To) {
+			res.Transfers = append(res.Transfers, t)
+		}
+	}
+	for _, t := range evnt.NFTTransfers {
+		if f.match(t.Addr, t.From, t.To) {
+			res.NFTTransfers = append(res.NFTTransfers, t)
+		}
+	}
+	for _, t := range evnt.MultiTransfers {
+		if f.match(t.Addr, t.From, t.To) {
+			res.MultiTransfers = append(res.MultiTransfers, t)
+		}
+	}
+	if len(res.Transfers) == 0 && len(res.NFTTransfers) == 0 && len(res.MultiTransfers) == 0 {
+		return nil
+	}
+	return res
+}
+
+// Subscription receives the events that match its filter
+type Subscription struct {
+	broker  *Broker
+	filter  *filterSet
+	eventCh chan *Event
+	dropped bool
+}
+
+// EventCh returns the channel of the events. The channel is closed when
+// the subscription is closed or dropped.
+func (s *Subscription) EventCh() <-chan *Event {
+	return s.eventCh
+}
+
+// Dropped returns true if the subscription was closed because the
+// subscriber did not keep up with the events
+func (s *Subscription) Dropped() bool {
+	s.broker.lock.Lock()
+	defer s.broker.lock.Unlock()
+
+	return s.dropped
+}
+
+// Close closes the subscription
+func (s *Subscription) Close() {
+	s.broker.remove(s, false)
+}
+
+// Broker fans out the transfers events to the subscribers. Publishing never
+// blocks, the subscribers that are too slow are dropped.
+type Broker struct {
+	lock sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBroker creates a new broker
+func NewBroker() *Broker {
+	return &Broker{
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe subscribes to the events that match the filter
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{
+		broker:  b,
+		filter:  newFilterSet(filter),
+		eventCh: make(chan *Event, subscriptionBuffer),
+	}
+
+	b.lock.Lock()
+	b.subs[sub] = struct{}{}
+	b.lock.Unlock()
+
+	return sub
+}
+
+func (b *Broker) remove(sub *Subscription, dropped bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.removeLocked(sub, dropped)
+}
+
+func (b *Broker) removeLocked(sub *Subscription, dropped bool) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	sub.dropped = dropped
+	close(sub.eventCh)
+}
+
+// Publish sends the event to the subscribers
+func (b *Broker) Publish(evnt *Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for sub := range b.subs {
+		filtered := sub.filter.apply(evnt)
+		if filtered == nil {
+			continue
+		}
+		select {
+		case sub.eventCh <- filtered:
+		default:
+			// the subscriber is not consuming the events
+			b.removeLocked(sub, true)
+		}
+	}
+}
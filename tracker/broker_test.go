@@ -0,0 +1,95 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+func TestBrokerFilter(t *testing.T) {
+	b := NewBroker()
+
+	addr1 := web3.Address{0x1}
+	addr2 := web3.Address{0x2}
+
+	sub := b.Subscribe(Filter{To: []web3.Address{addr1}})
+	defer sub.Close()
+
+	b.Publish(&Event{
+		Type: EventAdded,
+		Transfers: []*store.Transfer{
+			{To: addr1.String()},
+			{To: addr2.String()},
+		},
+		NFTTransfers: []*store.NFTTransfer{
+			{To: addr2.String()},
+		},
+	})
+	// no transfers match the filter
+	b.Publish(&Event{
+		Type:      EventAdded,
+		Transfers: []*store.Transfer{{To: addr2.String()}},
+	})
+	b.Publish(&Event{Type: EventRemoved, BlockHash: "0x1"})
+
+	evnt := <-sub.EventCh()
+	if len(evnt.Transfers) != 1 || evnt.Transfers[0].To != addr1.String() || len(evnt.NFTTransfers) != 0 {
+		t.Fatal("bad filtered event")
+	}
+	if evnt = <-sub.EventCh(); evnt.Type != EventRemoved {
+		t.Fatal("removed event expected")
+	}
+}
+
+func TestBrokerAccounts(t *testing.T) {
+	b := NewBroker()
+
+	addr1 := web3.Address{0x1}
+	addr2 := web3.Address{0x2}
+	addr3 := web3.Address{0x3}
+
+	sub := b.Subscribe(Filter{Accounts: []web3.Address{addr1}})
+	defer sub.Close()
+
+	// the account matches as the source or the destination
+	b.Publish(&Event{
+		Type: EventAdded,
+		Transfers: []*store.Transfer{
+			{From: addr1.String(), To: addr2.String()},
+			{From: addr2.String(), To: addr3.String()},
+			{From: addr3.String(), To: addr1.String()},
+		},
+	})
+	evnt := <-sub.EventCh()
+	if len(evnt.Transfers) != 2 || evnt.Transfers[0].From != addr1.String() || evnt.Transfers[1].To != addr1.String() {
+		t.Fatal("bad filtered event")
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker()
+
+	slow := b.Subscribe(Filter{})
+	fast := b.Subscribe(Filter{})
+	defer fast.Close()
+
+	for i := 0; i < subscriptionBuffer+1; i++ {
+		b.Publish(&Event{Type: EventRemoved})
+		<-fast.EventCh()
+	}
+
+	// the slow subscriber is dropped after its buffer is full
+	num := 0
+	for range slow.EventCh() {
+		num++
+	}
+	if num != subscriptionBuffer || !slow.Dropped() {
+		t.Fatal("slow subscriber not dropped")
+	}
+	if fast.Dropped() {
+		t.Fatal("fast subscriber dropped")
+	}
+	// closing a dropped subscription is a noop
+	slow.Close()
+}
@@ -0,0 +1,408 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/jsonrpc"
+	"github.com/umbracle/go-web3/jsonrpc/codec"
+)
+
+const (
+	// endpointCheckInterval is the interval between the health checks
+	// of the endpoints
+	endpointCheckInterval = 15 * time.Second
+
+	// endpointMaxLag is the number of blocks an endpoint can be behind
+	// the highest head of the endpoints to be healthy
+	endpointMaxLag = 5
+
+	// endpointMaxErrorRate is the error rate above which an endpoint is
+	// not healthy
+	endpointMaxErrorRate = 0.5
+
+	// endpointDecay is the weight of the last request in the error rate
+	// and the latency of an endpoint
+	endpointDecay = 0.2
+)
+
+// Caller makes json-rpc calls
+type Caller interface {
+	Call(method string, out interface{}, params ...interface{}) error
+}
+
+// EndpointConfig is the configuration of a json-rpc endpoint. The endpoints
+// with the lowest priority are used first and the requests are spread
+// among the endpoints of the same priority by their weight. The url is the
+// key of the endpoint in the config.
+type EndpointConfig struct {
+	URL      string `mapstructure:"-"`
+	Priority int    `mapstructure:"priority"`
+	Weight   int    `mapstructure:"weight"`
+}
+
+// endpoint is a json-rpc endpoint and its health
+type endpoint struct {
+	name     string
+	priority int
+	weight   int
+	caller   Caller
+
+	lock      sync.Mutex
+	errorRate float64
+	latency   time.Duration
+	head      uint64
+}
+
+// record updates the error rate and the latency of the endpoint with the
+// result of a request
+func (e *endpoint) record(latency time.Duration, failed bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	value := 0.0
+	if failed {
+		value = 1
+	}
+	e.errorRate = e.errorRate*(1-endpointDecay) + value*endpointDecay
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = time.Duration(float64(e.latency)*(1-endpointDecay) + float64(latency)*endpointDecay)
+	}
+
+	metricEndpointErrorRate.WithLabelValues(e.name).Set(e.errorRate)
+	metricEndpointLatency.WithLabelValues(e.name).Set(e.latency.Seconds())
+}
+
+// setHead updates the head of the chain seen by the endpoint
+func (e *endpoint) setHead(num uint64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.head = num
+	metricEndpointHead.WithLabelValues(e.name).Set(float64(num))
+}
+
+// healthy returns true if the error rate of the endpoint is low and it is
+// not behind the head of the chain
+func (e *endpoint) healthy(head uint64) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.errorRate <= endpointMaxErrorRate && e.head+endpointMaxLag >= head
+}
+
+// synced returns true if the endpoint may have synced the block. The
+// endpoints with an unknown head are assumed to be synced.
+func (e *endpoint) synced(num uint64) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.head == 0 || e.head >= num
+}
+
+// score is the weight of the endpoint scaled down by its error rate and
+// its latency
+func (e *endpoint) score() float64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	latency := e.latency
+	if latency < time.Millisecond {
+		latency = time.Millisecond
+	}
+	return float64(e.weight) * (1 - e.errorRate) / latency.Seconds()
+}
+
+// endpointName is the host of the url of the endpoint. The rest of the url
+// may include an api key.
+func endpointName(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return raw
+}
+
+// failoverClient is a json-rpc client over several endpoints. A request
+// is sent to a healthy endpoint of the lowest priority and it fails over
+// to the next endpoints if the endpoint cannot be reached or it is rate
+// limited. An endpoint is
+// not healthy if it fails often or if it is behind the other endpoints.
+type failoverClient struct {
+	logger    *log.Logger
+	endpoints []*endpoint
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// newFailoverClient creates a client for the endpoints
+func newFailoverClient(logger *log.Logger, configs []*EndpointConfig) (*failoverClient, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no json-rpc endpoints")
+	}
+	c := &failoverClient{
+		logger: logger,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, config := range configs {
+		client, err := jsonrpc.NewClient(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the client for %s: %v", endpointName(config.URL), err)
+		}
+		c.add(endpointName(config.URL), config, client)
+	}
+	return c, nil
+}
+
+func (c *failoverClient) add(name string, config *EndpointConfig, caller Caller) {
+	weight := config.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	c.endpoints = append(c.endpoints, &endpoint{
+		name:     name,
+		priority: config.Priority,
+		weight:   weight,
+		caller:   caller,
+	})
+}
+
+// head returns the highest head of the endpoints
+func (c *failoverClient) head() uint64 {
+	head := uint64(0)
+	for _, e := range c.endpoints {
+		e.lock.Lock()
+		if e.head > head {
+			head = e.head
+		}
+		e.lock.Unlock()
+	}
+	return head
+}
+
+// order returns the endpoints in the order they are tried. The healthy
+// endpoints go first by priority and, for the same priority, in a random
+// order weighted by their score. The endpoints that are not healthy are
+// only tried if the others fail.
+func (c *failoverClient) order() []*endpoint {
+	head := c.head()
+
+	type candidate struct {
+		e       *endpoint
+		healthy bool
+		key     float64
+	}
+	candidates := make([]*candidate, 0, len(c.endpoints))
+
+	c.lock.Lock()
+	for _, e := range c.endpoints {
+		// weighted random order (Efraimidis-Spirakis)
+		key := 0.0
+		if score := e.score(); score > 0 {
+			key = math.Pow(c.rand.Float64(), 1/score)
+		}
+		candidates = append(candidates, &candidate{e: e, healthy: e.healthy(head), key: key})
+	}
+	c.lock.Unlock()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.healthy != b.healthy {
+			return a.healthy
+		}
+		if a.e.priority != b.e.priority {
+			return a.e.priority < b.e.priority
+		}
+		return a.key > b.key
+	})
+
+	res := make([]*endpoint, 0, len(candidates))
+	for _, c := range candidates {
+		res = append(res, c.e)
+	}
+	return res
+}
+
+// logsToBlock returns the last block of the range of an eth_getLogs request
+// or zero if the request is not for a range of blocks
+func logsToBlock(method string, params []interface{}) uint64 {
+	if method != "eth_getLogs" || len(params) != 1 {
+		return 0
+	}
+	f, ok := params[0].(*logFilter)
+	if !ok || f.ToBlock == "" {
+		return 0
+	}
+	num, err := parseUint64(f.ToBlock)
+	if err != nil {
+		// i.e. latest
+		return 0
+	}
+	return num
+}
+
+// Call implements the Caller interface. The request fails over to the next
+// endpoint if the endpoint cannot be reached or it returns a rate limit or
+// a server error, any other error of the endpoint is returned. The logs of
+// a range of blocks are only queried from the endpoints that have synced
+// the last block of the range, a lagging endpoint returns no logs for the
+// blocks it does not have.
+func (c *failoverClient) Call(method string, out interface{}, params ...interface{}) error {
+	toBlock := logsToBlock(method, params)
+
+	var err error
+	tried := 0
+	for _, e := range c.order() {
+		if !e.synced(toBlock) {
+			continue
+		}
+		start := time.Now()
+		err = e.caller.Call(method, out, params...)
+		obj, ok := err.(*codec.ErrorObject)
+		if err == nil || ok && !isTransientRPC(obj) {
+			e.record(time.Since(start), false)
+			if tried != 0 {
+				metricEndpointFailovers.Inc()
+			}
+			if err == nil && method == "eth_blockNumber" {
+				// keep the head of the endpoint up to date between
+				// the health checks
+				if raw, ok := out.(*string); ok {
+					if num, err := parseUint64(*raw); err == nil {
+						e.setHead(num)
+					}
+				}
+			}
+			return err
+		}
+		tried++
+		e.record(time.Since(start), true)
+		c.logger.Printf("[WARN] JSON-RPC endpoint %s failed: %v", e.name, err)
+	}
+	if tried == 0 {
+		return &blockNotFoundError{block: strconv.FormatUint(toBlock, 10)}
+	}
+	return err
+}
+
+// check queries the head of every endpoint
+func (c *failoverClient) check() {
+	var wg sync.WaitGroup
+	for _, e := range c.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+
+			start := time.Now()
+			num, err := newEth(e.caller).BlockNumber()
+			e.record(time.Since(start), err != nil)
+			if err != nil {
+				c.logger.Printf("[WARN] JSON-RPC endpoint %s failed the health check: %v", e.name, err)
+				return
+			}
+			e.setHead(num)
+		}(e)
+	}
+	wg.Wait()
+
+	head := c.head()
+	for _, e := range c.endpoints {
+		if !e.healthy(head) {
+			c.logger.Printf("[WARN] JSON-RPC endpoint %s is not healthy", e.name)
+		}
+	}
+}
+
+// Run checks the health of the endpoints until the context is done
+func (c *failoverClient) Run(ctx context.Context) {
+	if len(c.endpoints) < 2 {
+		return
+	}
+	for {
+		c.check()
+
+		select {
+		case <-time.After(endpointCheckInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// eth is the eth namespace of the json-rpc api over a Caller
+type eth struct {
+	c Caller
+}
+
+func newEth(c Caller) *eth {
+	return &eth{c: c}
+}
+
+func parseUint64(str string) (uint64, error) {
+	if strings.HasPrefix(str, "0x") {
+		return strconv.ParseUint(str[2:], 16, 64)
+	}
+	return strconv.ParseUint(str, 10, 64)
+}
+
+// BlockNumber returns the number of the most recent block
+func (e *eth) BlockNumber() (uint64, error) {
+	var out string
+	if err := e.c.Call("eth_blockNumber", &out); err != nil {
+		return 0, err
+	}
+	return parseUint64(out)
+}
+
+// GetBlockByNumber returns a block by its number
+func (e *eth) GetBlockByNumber(i web3.BlockNumber, full bool) (*web3.Block, error) {
+	var b *web3.Block
+	if err := e.c.Call("eth_getBlockByNumber", &b, i.String(), full); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetBlockByHash returns a block by its hash
+func (e *eth) GetBlockByHash(hash web3.Hash, full bool) (*web3.Block, error) {
+	var b *web3.Block
+	if err := e.c.Call("eth_getBlockByHash", &b, hash, full); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ChainID returns the id of the chain
+func (e *eth) ChainID() (*big.Int, error) {
+	var out string
+	if err := e.c.Call("eth_chainId", &out); err != nil {
+		return nil, err
+	}
+	num, ok := new(big.Int).SetString(strings.TrimPrefix(out, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("bad chain id %s", out)
+	}
+	return num, nil
+}
+
+// Call executes a message call without creating a transaction
+func (e *eth) Call(msg *web3.CallMsg, block web3.BlockNumber) (string, error) {
+	var out string
+	if err := e.c.Call("eth_call", &out, msg, block.String()); err != nil {
+		return "", err
+	}
+	return out, nil
+}
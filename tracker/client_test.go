@@ -0,0 +1,265 @@
+package tracker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/jsonrpc"
+)
+
+// rpcNode is a json-rpc stand-in that only replies to eth_blockNumber. It
+// fails the requests while fail is set.
+type rpcNode struct {
+	lock  sync.Mutex
+	head  uint64
+	fail  bool
+	calls int
+}
+
+func (n *rpcNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.calls++
+	if n.fail {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": 1, "result": "0x%x"}`, n.head)
+}
+
+func (n *rpcNode) set(head uint64, fail bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.head = head
+	n.fail = fail
+	n.calls = 0
+}
+
+func (n *rpcNode) numCalls() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.calls
+}
+
+func newTestNodes(t *testing.T, configs ...*EndpointConfig) (*failoverClient, []*rpcNode, func()) {
+	nodes := []*rpcNode{}
+	servers := []*httptest.Server{}
+	for _, config := range configs {
+		node := &rpcNode{head: 100}
+		srv := httptest.NewServer(node)
+		config.URL = srv.URL
+
+		nodes = append(nodes, node)
+		servers = append(servers, srv)
+	}
+	c, err := newFailoverClient(log.New(ioutil.Discard, "", 0), configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeFn := func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+	return c, nodes, closeFn
+}
+
+func TestFailoverClient(t *testing.T) {
+	c, nodes, closeFn := newTestNodes(t, &EndpointConfig{Priority: 0}, &EndpointConfig{Priority: 1})
+	defer closeFn()
+
+	primary, fallback := nodes[0], nodes[1]
+	blockNumber := func() uint64 {
+		num, err := newEth(c).BlockNumber()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return num
+	}
+
+	// the requests go to the endpoint with the lowest priority
+	blockNumber()
+	if primary.numCalls() != 1 || fallback.numCalls() != 0 {
+		t.Fatal("the primary endpoint is not used")
+	}
+
+	// the requests fail over while the primary endpoint fails
+	primary.set(100, true)
+	for i := 0; i < 4; i++ {
+		blockNumber()
+	}
+	if primary.numCalls() != 4 || fallback.numCalls() != 4 {
+		t.Fatal("the requests do not fail over")
+	}
+
+	// the primary endpoint is not tried once its error rate is too high
+	primary.set(100, true)
+	blockNumber()
+	if primary.numCalls() != 0 {
+		t.Fatal("the primary endpoint is tried")
+	}
+
+	// the health check recovers the primary endpoint
+	primary.set(100, false)
+	c.check()
+	primary.set(100, false)
+	blockNumber()
+	if primary.numCalls() != 1 {
+		t.Fatal("the primary endpoint is not used after it recovers")
+	}
+
+	// the primary endpoint is skipped while it is behind the head
+	fallback.set(110, false)
+	c.check()
+	primary.set(100, false)
+	if num := blockNumber(); num != 110 || primary.numCalls() != 0 {
+		t.Fatal("the endpoint behind the head is used")
+	}
+
+	// all the endpoints fail
+	primary.set(100, true)
+	fallback.set(110, true)
+	if _, err := newEth(c).BlockNumber(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestFailoverClientRPCError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "error": {"code": 3, "message": "execution reverted"}}`))
+	}))
+	defer srv.Close()
+
+	c, nodes, closeFn := newTestNodes(t, &EndpointConfig{Priority: 1})
+	defer closeFn()
+
+	client, err := jsonrpc.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add("reverts", &EndpointConfig{}, client)
+
+	// the error of the endpoint itself is returned
+	if _, err := newEth(c).BlockNumber(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if nodes[0].numCalls() != 0 {
+		t.Fatal("the json-rpc error fails over")
+	}
+}
+
+func TestFailoverClientRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32005, "message": "daily request count exceeded"}}`))
+	}))
+	defer srv.Close()
+
+	c, nodes, closeFn := newTestNodes(t, &EndpointConfig{Priority: 1})
+	defer closeFn()
+
+	client, err := jsonrpc.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add("limited", &EndpointConfig{}, client)
+
+	// the rate limit fails over and the endpoint is not healthy
+	if num, err := newEth(c).BlockNumber(); err != nil || num != 100 {
+		t.Fatal("the rate limit does not fail over")
+	}
+	if nodes[0].numCalls() != 1 {
+		t.Fatal("the rate limit does not fail over")
+	}
+	limited := c.endpoints[1]
+	if limited.errorRate == 0 {
+		t.Fatal("the rate limit is not recorded")
+	}
+}
+
+func TestFailoverClientLogs(t *testing.T) {
+	var lock sync.Mutex
+	calls := make([]int, 2)
+	numCalls := func(i int) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return calls[i]
+	}
+
+	c := &failoverClient{
+		logger: log.New(ioutil.Discard, "", 0),
+		rand:   rand.New(rand.NewSource(1)),
+	}
+	for i := 0; i < 2; i++ {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			calls[i]++
+			lock.Unlock()
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": []}`))
+		}))
+		defer srv.Close()
+
+		client, err := jsonrpc.NewClient(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.add(fmt.Sprintf("node%d", i), &EndpointConfig{Priority: i}, client)
+	}
+	// the primary endpoint is behind but healthy
+	c.endpoints[0].setHead(100)
+	c.endpoints[1].setHead(103)
+
+	p := newProvider(c, nil, newBatchSizer(c.logger, 1000))
+	getLogs := func(from, to uint64) error {
+		filter := &web3.LogFilter{}
+		filter.SetFromUint64(from)
+		filter.SetToUint64(to)
+		_, err := p.GetLogs(filter)
+		return err
+	}
+
+	// the range is only queried from the endpoint that synced it
+	if err := getLogs(90, 102); err != nil {
+		t.Fatal(err)
+	}
+	if numCalls(0) != 0 || numCalls(1) != 1 {
+		t.Fatal("the logs are queried from a lagging endpoint")
+	}
+	if err := getLogs(90, 100); err != nil {
+		t.Fatal(err)
+	}
+	if numCalls(0) != 1 {
+		t.Fatal("the primary endpoint is not used")
+	}
+
+	// no endpoint synced the range
+	if err := getLogs(90, 110); err == nil || !isTransient(err) {
+		t.Fatalf("expected a transient error but found %v", err)
+	}
+}
+
+func TestFailoverClientWeights(t *testing.T) {
+	c, _, closeFn := newTestNodes(t, &EndpointConfig{Weight: 3}, &EndpointConfig{Weight: 1})
+	defer closeFn()
+
+	c.rand = rand.New(rand.NewSource(1))
+	first := 0
+	for i := 0; i < 1000; i++ {
+		if c.order()[0] == c.endpoints[0] {
+			first++
+		}
+	}
+	if first < 650 || first > 850 {
+		t.Fatalf("expected 3/4 of the requests but found %d", first)
+	}
+}
@@ -0,0 +1,224 @@
+package tracker
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/jsonrpc/codec"
+)
+
+// selectors of the token metadata methods
+var (
+	nameMethod        = []byte{0x06, 0xfd, 0xde, 0x03}
+	symbolMethod      = []byte{0x95, 0xd8, 0x9b, 0x41}
+	decimalsMethod    = []byte{0x31, 0x3c, 0xe5, 0x67}
+	totalSupplyMethod = []byte{0x18, 0x16, 0x0d, 0xdd}
+)
+
+// MetadataStore is the storage interface required to resolve the metadata
+// of the tokens
+type MetadataStore interface {
+	GetToken(token web3.Address) (*store.Token, error)
+	WriteTokenMetadata(token *store.Token) error
+}
+
+// Resolver resolves the metadata of the tokens with eth_call. The metadata
+// of a token is resolved only once, the first time the token is seen.
+type Resolver struct {
+	logger *log.Logger
+	client *eth
+	store  MetadataStore
+
+	lock     sync.Mutex
+	seen     map[web3.Address]struct{}
+	pending  []web3.Address
+	notifyCh chan struct{}
+}
+
+// NewResolver creates a new metadata resolver
+func NewResolver(logger *log.Logger, client Caller, store MetadataStore) *Resolver {
+	return &Resolver{
+		logger:   logger,
+		client:   newEth(client),
+		store:    store,
+		seen:     map[web3.Address]struct{}{},
+		pending:  []web3.Address{},
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+// Enqueue schedules the resolution of the tokens that were not seen before
+func (r *Resolver) Enqueue(tokens ...web3.Address) {
+	r.lock.Lock()
+	for _, token := range tokens {
+		if _, ok := r.seen[token]; ok {
+			continue
+		}
+		r.seen[token] = struct{}{}
+		r.pending = append(r.pending, token)
+	}
+	r.lock.Unlock()
+
+	select {
+	case r.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Resolver) next() (web3.Address, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.pending) == 0 {
+		return web3.Address{}, false
+	}
+	token := r.pending[0]
+	r.pending = r.pending[1:]
+	return token, true
+}
+
+// Run resolves the enqueued tokens until the context is done
+func (r *Resolver) Run(ctx context.Context) {
+	for {
+		token, ok := r.next()
+		if !ok {
+			select {
+			case <-r.notifyCh:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := r.resolveToken(token); err != nil {
+			r.logger.Printf("[ERR] Failed to resolve the metadata of %s: %v", token, err)
+
+			// try again the next time the token is seen
+			r.lock.Lock()
+			delete(r.seen, token)
+			r.lock.Unlock()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (r *Resolver) resolveToken(addr web3.Address) error {
+	token, err := r.store.GetToken(addr)
+	if err == store.ErrNotFound {
+		// the logs of the contract are not token transfers
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if token.Resolved {
+		return nil
+	}
+	if token, err = r.Resolve(addr); err != nil {
+		return err
+	}
+	return r.store.WriteTokenMetadata(token)
+}
+
+// Resolve queries the metadata of a token. The values of the methods that
+// are not implemented by the token are left empty.
+func (r *Resolver) Resolve(addr web3.Address) (*store.Token, error) {
+	token := &store.Token{
+		Addr:     addr.String(),
+		Resolved: true,
+	}
+
+	data, err := r.call(addr, nameMethod)
+	if err != nil {
+		return nil, err
+	}
+	token.Name = decodeString(data)
+
+	if data, err = r.call(addr, symbolMethod); err != nil {
+		return nil, err
+	}
+	token.Symbol = decodeString(data)
+
+	if data, err = r.call(addr, decimalsMethod); err != nil {
+		return nil, err
+	}
+	if decimals := decodeUint(data); decimals != nil && decimals.IsUint64() && decimals.Uint64() <= 255 {
+		// decimals is an uint8
+		d := decimals.Uint64()
+		token.Decimals = &d
+	}
+
+	if data, err = r.call(addr, totalSupplyMethod); err != nil {
+		return nil, err
+	}
+	if totalSupply := decodeUint(data); totalSupply != nil {
+		token.TotalSupply = totalSupply.String()
+	}
+	return token, nil
+}
+
+// call returns the output of a method of the token or nil if the call
+// reverts
+func (r *Resolver) call(addr web3.Address, method []byte) ([]byte, error) {
+	msg := &web3.CallMsg{
+		To:   addr,
+		Data: method,
+	}
+	out, err := r.client.Call(msg, web3.Latest)
+	if err != nil {
+		if _, ok := err.(*codec.ErrorObject); ok {
+			// the method reverted or it does not exist
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(out, "0x"))
+	if err != nil {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// decodeString decodes the output of a method that returns a string. Some
+// tokens return a bytes32 instead.
+func decodeString(data []byte) string {
+	var raw []byte
+	if len(data) == 32 {
+		raw = data
+	} else if len(data) >= 64 {
+		offset := new(big.Int).SetBytes(data[:32])
+		if !offset.IsUint64() || offset.Uint64() > uint64(len(data)-64) {
+			return ""
+		}
+		start := offset.Uint64() + 32
+		size := new(big.Int).SetBytes(data[start-32 : start])
+		if !size.IsUint64() || size.Uint64() > uint64(len(data))-start {
+			return ""
+		}
+		raw = data[start : start+size.Uint64()]
+	}
+
+	// remove the padding of the bytes32 values and any invalid character
+	return strings.Map(func(c rune) rune {
+		if c == 0 || c == utf8.RuneError {
+			return -1
+		}
+		return c
+	}, string(raw))
+}
+
+// decodeUint decodes the output of a method that returns an uint256
+func decodeUint(data []byte) *big.Int {
+	if len(data) < 32 {
+		return nil
+	}
+	return new(big.Int).SetBytes(data[:32])
+}
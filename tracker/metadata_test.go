@@ -0,0 +1,241 @@
+package tracker
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/jsonrpc"
+)
+
+// mockContract is the output of each method of a contract. The methods
+// without an output revert.
+type mockContract map[string][]byte
+
+// newMockServer starts a json-rpc server that replies to eth_call with the
+// outputs of the mock contracts
+func newMockServer(t *testing.T, contracts map[web3.Address]mockContract) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req struct {
+			ID     uint64
+			Method string
+			Params []json.RawMessage
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Method != "eth_call" {
+			t.Fatalf("unexpected method %s", req.Method)
+		}
+		var msg struct {
+			To   web3.Address
+			Data string
+		}
+		if err := json.Unmarshal(req.Params[0], &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+		}
+		if out, ok := contracts[msg.To][strings.TrimPrefix(msg.Data, "0x")]; ok {
+			resp["result"] = "0x" + hex.EncodeToString(out)
+		} else {
+			resp["error"] = map[string]interface{}{
+				"code":    -32000,
+				"message": "execution reverted",
+			}
+		}
+		data, err = json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}))
+}
+
+func encodeUint(i int64) []byte {
+	buf := make([]byte, 32)
+	b := big.NewInt(i).Bytes()
+	copy(buf[32-len(b):], b)
+	return buf
+}
+
+func encodeString(s string) []byte {
+	size := (len(s) + 31) / 32 * 32
+	data := append(encodeUint(32), encodeUint(int64(len(s)))...)
+	return append(data, append([]byte(s), make([]byte, size-len(s))...)...)
+}
+
+func encodeBytes32(s string) []byte {
+	buf := make([]byte, 32)
+	copy(buf, s)
+	return buf
+}
+
+func TestResolverMetadata(t *testing.T) {
+	name := hex.EncodeToString(nameMethod)
+	symbol := hex.EncodeToString(symbolMethod)
+	decimals := hex.EncodeToString(decimalsMethod)
+	totalSupply := hex.EncodeToString(totalSupplyMethod)
+
+	standard := web3.Address{0x1}
+	bytes32 := web3.Address{0x2}
+	reverts := web3.Address{0x3}
+	malformed := web3.Address{0x4}
+
+	srv := newMockServer(t, map[web3.Address]mockContract{
+		standard: {
+			name:        encodeString("Token"),
+			symbol:      encodeString("TKN"),
+			decimals:    encodeUint(18),
+			totalSupply: encodeUint(1000),
+		},
+		bytes32: {
+			name:        encodeBytes32("Maker"),
+			symbol:      encodeBytes32("MKR"),
+			decimals:    encodeUint(18),
+			totalSupply: encodeUint(10),
+		},
+		reverts: {
+			// only the total supply is implemented
+			totalSupply: encodeUint(5),
+		},
+		malformed: {
+			name:     append(encodeUint(1000), encodeUint(1)...),
+			symbol:   []byte{},
+			decimals: encodeUint(256),
+		},
+	})
+	defer srv.Close()
+
+	client, err := jsonrpc.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(log.New(ioutil.Discard, "", 0), client, nil)
+
+	eighteen := uint64(18)
+	cases := []*store.Token{
+		{
+			Addr:        standard.String(),
+			Name:        "Token",
+			Symbol:      "TKN",
+			Decimals:    &eighteen,
+			TotalSupply: "1000",
+			Resolved:    true,
+		},
+		{
+			Addr:        bytes32.String(),
+			Name:        "Maker",
+			Symbol:      "MKR",
+			Decimals:    &eighteen,
+			TotalSupply: "10",
+			Resolved:    true,
+		},
+		{
+			Addr:        reverts.String(),
+			TotalSupply: "5",
+			Resolved:    true,
+		},
+		{
+			Addr:     malformed.String(),
+			Resolved: true,
+		},
+	}
+	for _, c := range cases {
+		token, err := r.Resolve(web3.HexToAddress(c.Addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(token, c) {
+			t.Fatalf("bad metadata for %s: %v", c.Addr, token)
+		}
+	}
+}
+
+func TestResolverRun(t *testing.T) {
+	token := web3.Address{0x1}
+
+	srv := newMockServer(t, map[web3.Address]mockContract{
+		token: {
+			hex.EncodeToString(nameMethod): encodeString("Token"),
+		},
+	})
+	defer srv.Close()
+
+	client, err := jsonrpc.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := memory.New()
+	if _, err := s.WriteReceipt([]*web3.Log{
+		{
+			Address: token,
+			Topics:  []web3.Hash{store.Topics()[0], {}, {}},
+			Data:    encodeUint(1),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewResolver(log.New(ioutil.Discard, "", 0), client, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// the second token is not tracked by the store
+	r.Enqueue(token, web3.Address{0x2})
+
+	for i := 0; i < 100; i++ {
+		metadata, err := s.GetToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.Resolved {
+			if metadata.Name != "Token" {
+				t.Fatalf("bad name %s", metadata.Name)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("token not resolved")
+}
+
+func TestResolverUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client, err := jsonrpc.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(log.New(ioutil.Discard, "", 0), client, nil)
+
+	// the token is not marked as resolved if the endpoint fails
+	if _, err := r.Resolve(web3.Address{0x1}); err == nil {
+		t.Fatal("it should fail")
+	}
+}
@@ -0,0 +1,83 @@
+package tracker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricBlocksSynced = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "tokentracker",
+		Subsystem: "tracker",
+		Name:      "blocks_synced",
+		Help:      "Last block indexed by the tracker.",
+	})
+	metricHeadLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "tokentracker",
+		Subsystem: "tracker",
+		Name:      "head_lag_blocks",
+		Help:      "Number of blocks between the head of the chain and the last indexed block.",
+	})
+	metricLogsWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tokentracker",
+		Subsystem: "tracker",
+		Name:      "logs_written_total",
+		Help:      "Number of logs written to the store.",
+	})
+	metricLogsRemoved = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tokentracker",
+		Subsystem: "tracker",
+		Name:      "logs_removed_total",
+		Help:      "Number of logs removed from the store by a reorg.",
+	})
+	metricEndpointErrorRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "tokentracker",
+		Subsystem: "jsonrpc",
+		Name:      "endpoint_error_rate",
+		Help:      "Decaying rate of the failed requests to a JSON-RPC endpoint.",
+	}, []string{"endpoint"})
+	metricEndpointLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "tokentracker",
+		Subsystem: "jsonrpc",
+		Name:      "endpoint_latency_seconds",
+		Help:      "Decaying average latency of the requests to a JSON-RPC endpoint.",
+	}, []string{"endpoint"})
+	metricEndpointHead = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "tokentracker",
+		Subsystem: "jsonrpc",
+		Name:      "endpoint_head",
+		Help:      "Head of the chain seen by a JSON-RPC endpoint.",
+	}, []string{"endpoint"})
+	metricEndpointFailovers = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tokentracker",
+		Subsystem: "jsonrpc",
+		Name:      "failovers_total",
+		Help:      "Number of requests served by a fallback JSON-RPC endpoint after a failure.",
+	})
+	metricBatchSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "tokentracker",
+		Subsystem: "tracker",
+		Name:      "batch_size_blocks",
+		Help:      "Number of blocks queried at once with eth_getLogs.",
+	})
+	metricReorgs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "tokentracker",
+		Subsystem: "tracker",
+		Name:      "reorgs_total",
+		Help:      "Number of chain reorgs.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricBlocksSynced,
+		metricHeadLag,
+		metricLogsWritten,
+		metricLogsRemoved,
+		metricReorgs,
+		metricBatchSize,
+		metricEndpointErrorRate,
+		metricEndpointLatency,
+		metricEndpointHead,
+		metricEndpointFailovers,
+	)
+}
@@ -0,0 +1,91 @@
+package tracker
+
+import (
+	"github.com/umbracle/go-web3"
+)
+
+// provider is the json-rpc provider of the tracker. The filter of the
+// tracker only matches one value for each topic, the provider queries
+// instead the logs of any of the token transfer events. The ranges of
+// blocks are queried in batches of an adaptive size.
+type provider struct {
+	*eth
+
+	client Caller
+	topics []web3.Hash
+	batch  *batchSizer
+}
+
+func newProvider(client Caller, topics []web3.Hash, batch *batchSizer) *provider {
+	return &provider{
+		eth:    newEth(client),
+		client: client,
+		topics: topics,
+		batch:  batch,
+	}
+}
+
+type logFilter struct {
+	Address   []web3.Address `json:"address,omitempty"`
+	Topics    [][]web3.Hash  `json:"topics"`
+	BlockHash *web3.Hash     `json:"blockHash,omitempty"`
+	FromBlock string         `json:"fromBlock,omitempty"`
+	ToBlock   string         `json:"toBlock,omitempty"`
+}
+
+// GetLogs returns the logs of the token transfer events in the range of
+// the filter. The range is split in batches that shrink if the provider
+// rejects them and grow back if the responses are small.
+func (p *provider) GetLogs(filter *web3.LogFilter) ([]*web3.Log, error) {
+	f := &logFilter{
+		Address:   filter.Address,
+		Topics:    [][]web3.Hash{p.topics},
+		BlockHash: filter.BlockHash,
+	}
+	if filter.From == nil || filter.To == nil || *filter.From < 0 || *filter.To < 0 {
+		if filter.From != nil {
+			f.FromBlock = filter.From.String()
+		}
+		if filter.To != nil {
+			f.ToBlock = filter.To.String()
+		}
+		return p.getLogs(f)
+	}
+
+	from, to := uint64(*filter.From), uint64(*filter.To)
+
+	logs := []*web3.Log{}
+	for from <= to {
+		size := p.batch.get()
+		dst := from + size - 1
+		if dst > to || dst < from {
+			dst = to
+		}
+		f.FromBlock = web3.BlockNumber(from).String()
+		f.ToBlock = web3.BlockNumber(dst).String()
+
+		res, err := p.getLogs(f)
+		if err != nil {
+			if isBatchError(err) && p.batch.shrink(dst-from+1) {
+				continue
+			}
+			return nil, err
+		}
+		p.batch.grow(dst-from+1, len(res))
+		logs = append(logs, res...)
+
+		if dst == to {
+			break
+		}
+		from = dst + 1
+	}
+	return logs, nil
+}
+
+func (p *provider) getLogs(f *logFilter) ([]*web3.Log, error) {
+	var out []*web3.Log
+	if err := p.client.Call("eth_getLogs", &out, f); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
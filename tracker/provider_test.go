@@ -0,0 +1,145 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/jsonrpc"
+)
+
+func TestProviderGetLogs(t *testing.T) {
+	var params []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req struct {
+			Method string
+			Params []map[string]interface{}
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Method != "eth_getLogs" {
+			t.Fatalf("unexpected method %s", req.Method)
+		}
+		params = req.Params
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "result": []}`))
+	}))
+	defer srv.Close()
+
+	client, err := jsonrpc.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := []web3.Hash{{0x1}, {0x2}}
+	p := newProvider(client, topics, newBatchSizer(log.New(ioutil.Discard, "", 0), 100))
+
+	filter := &web3.LogFilter{}
+	filter.SetFromUint64(1)
+	filter.SetToUint64(16)
+	if _, err := p.GetLogs(filter); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"fromBlock": "0x1",
+		"toBlock":   "0x10",
+		"topics": []interface{}{
+			[]interface{}{topics[0].String(), topics[1].String()},
+		},
+	}
+	if len(params) != 1 || !reflect.DeepEqual(params[0], expected) {
+		t.Fatalf("bad filter %v", params)
+	}
+}
+
+func TestProviderBatchSize(t *testing.T) {
+	// the provider rejects the ranges with more than 8 blocks
+	var ranges [][2]uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req struct {
+			Params []logFilter
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatal(err)
+		}
+		from, to := parseHex(t, req.Params[0].FromBlock), parseHex(t, req.Params[0].ToBlock)
+		ranges = append(ranges, [2]uint64{from, to})
+
+		if to-from+1 > 8 {
+			w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32005, "message": "query returned more than 10000 results"}}`))
+			return
+		}
+		logs := []json.RawMessage{}
+		for i := from; i <= to; i++ {
+			buf, err := chainLog(i).MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			logs = append(logs, buf)
+		}
+		buf, err := json.Marshal(logs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, `{"jsonrpc": "2.0", "id": 1, "result": %s}`, buf)
+	}))
+	defer srv.Close()
+
+	client, err := jsonrpc.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProvider(client, []web3.Hash{erc20Topic}, newBatchSizer(log.New(ioutil.Discard, "", 0), 32))
+
+	getLogs := func(from, to uint64) []*web3.Log {
+		filter := &web3.LogFilter{}
+		filter.SetFromUint64(from)
+		filter.SetToUint64(to)
+		logs, err := p.GetLogs(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logs
+	}
+
+	// the batch size shrinks until the provider accepts the range
+	logs := getLogs(0, 39)
+	if len(logs) != 40 {
+		t.Fatalf("expected 40 logs but found %d", len(logs))
+	}
+	for indx, l := range logs {
+		if l.BlockNumber != uint64(indx) {
+			t.Fatalf("bad log %d", indx)
+		}
+	}
+	expected := [][2]uint64{{0, 31}, {0, 15}, {0, 7}, {8, 23}, {8, 15}, {16, 31}, {16, 23}, {24, 39}, {24, 31}, {32, 39}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Fatalf("bad ranges %v", ranges)
+	}
+
+	// the batch size only shrinks to one block
+	p.batch = newBatchSizer(log.New(ioutil.Discard, "", 0), 1)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc": "2.0", "id": 1, "error": {"code": -32000, "message": "query timeout exceeded"}}`))
+	})
+	filter := &web3.LogFilter{}
+	filter.SetFromUint64(0)
+	filter.SetToUint64(1)
+	if _, err := p.GetLogs(filter); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,232 @@
+package tracker
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/jsonrpc/codec"
+	"github.com/umbracle/go-web3/tracker"
+)
+
+const (
+	// syncMaxRetries is the number of consecutive failures without
+	// progress after which a transient error is fatal
+	syncMaxRetries = 10
+
+	// syncBaseBackoff is the delay before the first retry. It doubles
+	// with each retry up to syncMaxBackoff.
+	syncBaseBackoff = time.Second
+	syncMaxBackoff  = time.Minute
+)
+
+// transientErrors are the messages of the errors of the json-rpc endpoints
+// and the stores that may succeed if retried
+var transientErrors = []string{
+	"connection",
+	"timeout",
+	"timed out",
+	"broken pipe",
+	"eof",
+	"database is locked",
+}
+
+// transientRPCErrors are the messages of the -32000 server errors of the
+// json-rpc endpoints that may succeed if retried
+var transientRPCErrors = []string{
+	"timeout",
+	"timed out",
+	"limit",
+	"rate",
+	"too many requests",
+	"busy",
+	"unavailable",
+}
+
+// blockNotFoundError is returned when an endpoint does not know a block,
+// i.e. it is behind the other endpoints
+type blockNotFoundError struct {
+	block string
+}
+
+func (e *blockNotFoundError) Error() string {
+	return fmt.Sprintf("block %s not found", e.block)
+}
+
+// isTransientRPC returns true if a json-rpc error is a rate limit or a
+// server error. Any other error (i.e. invalid params, method not found or
+// execution reverted) fails again if retried.
+func isTransientRPC(err *codec.ErrorObject) bool {
+	switch {
+	case err.Code == -32005:
+		// limit exceeded
+		return true
+	case err.Code == 429 || err.Code >= 500 && err.Code <= 599:
+		// some providers return the http status as the code
+		return true
+	case err.Code == -32000:
+		msg := strings.ToLower(err.Message)
+		for _, e := range transientRPCErrors {
+			if strings.Contains(msg, e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTransient returns true if the operation that failed with err may
+// succeed if retried
+func isTransient(err error) bool {
+	switch obj := err.(type) {
+	case net.Error:
+		return true
+	case *codec.ErrorObject:
+		return isTransientRPC(obj)
+	case *blockNotFoundError:
+		return true
+	case *json.SyntaxError:
+		// the transport does not check the http status, a 429 or 5xx
+		// response of a proxy without a json body fails to decode
+		return true
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == driver.ErrBadConn {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, e := range transientErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncBackoff returns the time before the next retry of an operation
+func syncBackoff(attempts uint64) time.Duration {
+	delay := syncBaseBackoff
+	for i := uint64(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= syncMaxBackoff {
+			return syncMaxBackoff
+		}
+	}
+	return delay
+}
+
+// retry runs fn until it succeeds, it fails with an error that is not
+// transient or it fails syncMaxRetries times in a row without the tracker
+// indexing new blocks. The context error is returned if it is done while
+// waiting to retry.
+func (t *TokenTracker) retry(ctx context.Context, op string, fn func() error) error {
+	attempts := uint64(0)
+	lastBlock := t.status.status().LastBlock
+
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isTransient(err) {
+			return err
+		}
+		if num := t.status.status().LastBlock; num != lastBlock {
+			attempts, lastBlock = 0, num
+		}
+		attempts++
+		if attempts >= syncMaxRetries {
+			return err
+		}
+
+		delay := t.retryDelay(attempts)
+		t.logger.Printf("[WARN] Failed to %s, retrying in %s: %v", op, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// errTrackerClosed is returned by the store of a go-web3 tracker stopped
+var errTrackerClosed = errors.New("the tracker is stopped")
+
+// closableStore is the store of a go-web3 tracker that refuses the writes
+// once closed. The pinned go-web3 version does not wait for its polling to
+// stop, a tracker stopped would overwrite the last block rewound otherwise.
+type closableStore struct {
+	tracker.Store
+
+	lock   sync.Mutex
+	closed bool
+}
+
+func (s *closableStore) write(fn func() error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errTrackerClosed
+	}
+	return fn()
+}
+
+// StoreLogs implements the tracker.Store interface
+func (s *closableStore) StoreLogs(logs []*web3.Log) error {
+	return s.write(func() error { return s.Store.StoreLogs(logs) })
+}
+
+// RemoveLogs implements the tracker.Store interface
+func (s *closableStore) RemoveLogs(indx uint64) error {
+	return s.write(func() error { return s.Store.RemoveLogs(indx) })
+}
+
+// Set implements the tracker.Store interface
+func (s *closableStore) Set(k, v []byte) error {
+	return s.write(func() error { return s.Store.Set(k, v) })
+}
+
+// close refuses the next writes, it waits for the write in progress
+func (s *closableStore) close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+}
+
+// firstBlock returns the first block of an event or false if it has none
+func firstBlock(evnt *tracker.Event) (uint64, bool) {
+	nums := []uint64{}
+	for _, b := range evnt.Added {
+		nums = append(nums, b.Number)
+	}
+	for _, b := range evnt.Removed {
+		nums = append(nums, b.Number)
+	}
+	for _, log := range evnt.AddedLogs {
+		nums = append(nums, log.BlockNumber)
+	}
+	for _, log := range evnt.RemovedLogs {
+		nums = append(nums, log.BlockNumber)
+	}
+	if len(nums) == 0 {
+		return 0, false
+	}
+	first := nums[0]
+	for _, num := range nums[1:] {
+		if num < first {
+			first = num
+		}
+	}
+	return first, true
+}
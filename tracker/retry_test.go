@@ -0,0 +1,196 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/jsonrpc/codec"
+	"github.com/umbracle/go-web3/tracker"
+)
+
+// failingStore fails the writes of the receipts with err the first fails
+// times
+type failingStore struct {
+	*memory.Store
+
+	lock  sync.Mutex
+	err   error
+	fails int
+}
+
+func (f *failingStore) WriteReceipt(logs []*web3.Log) ([]*web3.Log, error) {
+	f.lock.Lock()
+	if f.fails != 0 {
+		f.fails--
+		f.lock.Unlock()
+		return nil, f.err
+	}
+	f.lock.Unlock()
+	return f.Store.WriteReceipt(logs)
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{io.EOF, true},
+		{&json.SyntaxError{}, true},
+		{&blockNotFoundError{block: "1"}, true},
+		{errors.New("dial tcp 127.0.0.1:8545: connect: connection refused"), true},
+		{errors.New("timeout"), true},
+		{errors.New("bad genesis"), false},
+		{errors.New(`pq: relation "transfers" does not exist`), false},
+	}
+	for _, c := range cases {
+		if isTransient(c.err) != c.transient {
+			t.Fatalf("bad transient %v for %v", !c.transient, c.err)
+		}
+	}
+}
+
+func TestIsTransientRPC(t *testing.T) {
+	cases := []struct {
+		err       *codec.ErrorObject
+		transient bool
+	}{
+		// rate limits and server errors
+		{&codec.ErrorObject{Code: -32005, Message: "daily request count exceeded"}, true},
+		{&codec.ErrorObject{Code: -32000, Message: "request timed out"}, true},
+		{&codec.ErrorObject{Code: -32000, Message: "rate limit exceeded"}, true},
+		{&codec.ErrorObject{Code: -32000, Message: "server is busy"}, true},
+		{&codec.ErrorObject{Code: 429, Message: "too many requests"}, true},
+		{&codec.ErrorObject{Code: 503, Message: "service unavailable"}, true},
+
+		// deterministic errors
+		{&codec.ErrorObject{Code: -32602, Message: "invalid params"}, false},
+		{&codec.ErrorObject{Code: -32601, Message: "the method eth_foo does not exist"}, false},
+		{&codec.ErrorObject{Code: -32600, Message: "invalid request"}, false},
+		{&codec.ErrorObject{Code: -32000, Message: "execution reverted"}, false},
+		{&codec.ErrorObject{Code: 3, Message: "execution reverted"}, false},
+	}
+	for _, c := range cases {
+		if isTransient(c.err) != c.transient {
+			t.Fatalf("bad transient %v for %v", !c.transient, c.err)
+		}
+	}
+}
+
+func TestSyncRetry(t *testing.T) {
+	chain := &mockChain{t: t, head: 20}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	s := &failingStore{
+		Store: memory.New(),
+		err:   errors.New("dial tcp 127.0.0.1:5432: connect: connection refused"),
+		fails: 2,
+	}
+	tt := newBackfillTracker(t, srv.URL, s, tracker.NewInmemStore())
+	tt.config.Workers = 1
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tt.Sync(context.Background())
+	}()
+
+	// the transfers are written once the store recovers
+	for i := 0; ; i++ {
+		transfers, err := s.GetTokenTransfers(store.TransfersFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(transfers) == 21 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("expected 21 transfers but found %d", len(transfers))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s.lock.Lock()
+	fails := s.fails
+	s.lock.Unlock()
+	if fails != 0 {
+		t.Fatal("the writes are not retried")
+	}
+
+	tt.Stop()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncFatal(t *testing.T) {
+	chain := &mockChain{t: t, head: 20}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	fatalErr := errors.New(`pq: relation "transfers" does not exist`)
+	s := &failingStore{
+		Store: memory.New(),
+		err:   fatalErr,
+		fails: 1,
+	}
+	tt := newBackfillTracker(t, srv.URL, s, tracker.NewInmemStore())
+	tt.config.Workers = 1
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tt.Sync(context.Background())
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != fatalErr {
+			t.Fatalf("expected the store error but found %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sync does not stop")
+	}
+	tt.Stop()
+}
+
+func TestClosableStore(t *testing.T) {
+	s := &closableStore{Store: tracker.NewInmemStore()}
+	if err := s.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writes of a tracker stopped are refused
+	s.close()
+	if err := s.Set([]byte("a"), []byte("2")); err != errTrackerClosed {
+		t.Fatalf("expected the write to be refused but found %v", err)
+	}
+	if err := s.StoreLogs([]*web3.Log{{}}); err != errTrackerClosed {
+		t.Fatalf("expected the write to be refused but found %v", err)
+	}
+	if buf, err := s.Get([]byte("a")); err != nil || string(buf) != "1" {
+		t.Fatalf("expected 1 but found %s", buf)
+	}
+}
+
+func TestFirstBlock(t *testing.T) {
+	evnt := &tracker.Event{
+		Added:       []*web3.Block{chainBlock(12), chainBlock(13)},
+		Removed:     []*web3.Block{chainBlock(11)},
+		AddedLogs:   []*web3.Log{chainLog(12)},
+		RemovedLogs: []*web3.Log{chainLog(11)},
+	}
+	if first, ok := firstBlock(evnt); !ok || first != 11 {
+		t.Fatalf("expected the first block 11 but found %d", first)
+	}
+	if _, ok := firstBlock(&tracker.Event{}); ok {
+		t.Fatal("expected no first block")
+	}
+}
@@ -0,0 +1,86 @@
+package tracker
+
+import (
+	"sync"
+)
+
+// Status is the sync status of the tracker
+type Status struct {
+	// LastBlock is the last indexed block
+	LastBlock uint64
+
+	// Head is the last known block of the chain
+	Head uint64
+
+	// Lag is the number of blocks between the head and the last
+	// indexed block
+	Lag uint64
+
+	// Synced is true once the historical sync is done and the tracker
+	// follows the head of the chain
+	Synced bool
+
+	// BatchSize is the effective number of blocks queried at once
+	// with eth_getLogs
+	BatchSize uint64
+}
+
+// syncStatus tracks the sync status. It is safe for concurrent use.
+type syncStatus struct {
+	lock      sync.Mutex
+	lastBlock uint64
+	head      uint64
+	synced    bool
+}
+
+// setHead updates the head of the chain
+func (s *syncStatus) setHead(num uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if num > s.head {
+		s.head = num
+	}
+	s.report()
+}
+
+// indexed updates the last indexed block. The head is at least the last
+// indexed block.
+func (s *syncStatus) indexed(num uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if num > s.lastBlock {
+		s.lastBlock = num
+	}
+	if num > s.head {
+		s.head = num
+	}
+	s.report()
+}
+
+// setSynced marks the historical sync as done
+func (s *syncStatus) setSynced() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.synced = true
+}
+
+// report updates the metrics of the status
+func (s *syncStatus) report() {
+	metricBlocksSynced.Set(float64(s.lastBlock))
+	metricHeadLag.Set(float64(s.head - s.lastBlock))
+}
+
+func (s *syncStatus) status() *Status {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return &Status{
+		LastBlock: s.lastBlock,
+		Head:      s.head,
+		Lag:       s.head - s.lastBlock,
+		Synced:    s.synced,
+	}
+}
@@ -0,0 +1,65 @@
+package tracker
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
+	"github.com/umbracle/go-web3/tracker"
+)
+
+func TestSyncStatus(t *testing.T) {
+	s := &syncStatus{}
+	s.setHead(100)
+
+	// historical sync
+	s.indexed(40)
+	s.indexed(30)
+	if status := s.status(); status.LastBlock != 40 || status.Head != 100 || status.Lag != 60 || status.Synced {
+		t.Fatalf("bad status %v", status)
+	}
+
+	// the new blocks move the head
+	s.setSynced()
+	s.indexed(100)
+	s.indexed(101)
+	if status := s.status(); status.LastBlock != 101 || status.Head != 101 || status.Lag != 0 || !status.Synced {
+		t.Fatalf("bad status %v", status)
+	}
+}
+
+func TestTrackHead(t *testing.T) {
+	chain := &mockChain{t: t, head: 20}
+	srv := httptest.NewServer(chain)
+	defer srv.Close()
+
+	tt := newBackfillTracker(t, srv.URL, memory.New(), tracker.NewInmemStore())
+	tt.headInterval = 10 * time.Millisecond
+	tt.status.indexed(20)
+	tt.status.setSynced()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tt.trackHead(ctx)
+
+	// the head advances while the indexing stalls
+	chain.lock.Lock()
+	chain.head = 50
+	chain.lock.Unlock()
+
+	for i := 0; ; i++ {
+		status := tt.Status()
+		if status.Head == 50 {
+			if status.LastBlock != 20 || status.Lag != 30 {
+				t.Fatalf("bad status %v", status)
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("the head is not refreshed: %v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -3,17 +3,20 @@ package tracker
 import (
 	"context"
 	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/cheggaaa/pb/v3"
+	"github.com/ferranbt/go-eth-token-tracker/store"
 	"github.com/umbracle/go-web3"
-	"github.com/umbracle/go-web3/jsonrpc"
 	"github.com/umbracle/go-web3/tracker"
 	trackerboltdb "github.com/umbracle/go-web3/tracker/boltdb"
 )
 
-var (
-	transferEventTopic = web3.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
-)
+// headCheckInterval is the interval between the queries of the head of the
+// chain that track the lag of the tracker
+const headCheckInterval = 15 * time.Second
 
 // Config is the configuration for the token tracker.
 type Config struct {
@@ -21,6 +24,24 @@ type Config struct {
 	BoltDBPath  string `mapstructure:"dbpath"`
 	BatchSize   int64  `mapstructure:"batchsize"`
 	ProgressBar bool   `mapstructure:"progressbar"`
+
+	// Endpoints are the json-rpc endpoints by url with their priority
+	// and weight. They are used instead of Endpoint if set.
+	Endpoints map[string]EndpointConfig `mapstructure:"endpoints"`
+
+	// Confirmations is the number of blocks on top of a transfer to
+	// consider it confirmed
+	Confirmations uint64 `mapstructure:"confirmations"`
+
+	// Workers is the number of workers that backfill the historical
+	// blocks in shards of ShardSize blocks. The blocks are synced in
+	// sequence with less than two workers.
+	Workers   int    `mapstructure:"workers"`
+	ShardSize uint64 `mapstructure:"shardsize"`
+
+	// AllowPrivateWebhooks allows the deliveries to loopback, private and
+	// link-local addresses. It is set from the option of the http config.
+	AllowPrivateWebhooks bool `mapstructure:"-"`
 }
 
 // DefaultConfig returns the default configuration
@@ -30,39 +51,102 @@ func DefaultConfig() *Config {
 		BatchSize:   1000,
 		ProgressBar: true,
 		Endpoint:    "https://mainnet.infura.io",
+		Workers:     1,
+		ShardSize:   100000,
 	}
 }
 
 // Store is the storage interface required by the tracker
 type Store interface {
-	WriteReceipt(logs []*web3.Log) error
+	WriteBlocks(blocks []*store.Block) error
+	WriteReceipt(logs []*web3.Log) ([]*web3.Log, error)
 	RemoveReceipts(hash web3.Hash) error
+	ConfirmTransfers(blockNumber uint64) error
 	Close() error
+
+	MetadataStore
+	store.WebhookStore
 }
 
-// TokenTracker tracks ERC20 tokens
+// TokenTracker tracks ERC20, ERC721 and ERC1155 tokens
 type TokenTracker struct {
-	logger  *log.Logger
-	store   Store
-	config  *Config
-	tracker *tracker.Tracker
-	client  *jsonrpc.Client
+	logger   *log.Logger
+	store    Store
+	config   *Config
+	client   *failoverClient
+	provider *provider
+	resolver *Resolver
+	blocks   *blockCache
+	status   *syncStatus
+	broker   *Broker
+	notifier *Notifier
+
+	// trackerStore and trackerConfig are the store and the config of
+	// the go-web3 tracker
+	trackerStore  tracker.Store
+	trackerConfig *tracker.Config
+
+	// retryDelay returns the time before retrying a failed operation
+	retryDelay func(attempts uint64) time.Duration
+
+	// headInterval is the interval between the queries of the head of
+	// the chain
+	headInterval time.Duration
+
+	lock    sync.Mutex
 	closeCh context.CancelFunc
+	doneCh  chan struct{}
+	stopped bool
+
+	// syncCh receives the blocks synced
+	syncCh chan uint64
+
+	// confirmed is the last block with confirmed transfers. It is only
+	// used by the sync loop.
+	confirmed uint64
+
+	// rewindTo is the first block synced again by the go-web3 tracker once
+	// it restarts if rewinding is set and removed are the logs of the
+	// blocks reorged not removed yet. They are only used by the sync loop.
+	rewindTo  uint64
+	rewinding bool
+	removed   []*web3.Log
 }
 
 // NewTokenTracker creates a new token tracker
-func NewTokenTracker(logger *log.Logger, config *Config, store Store) (*TokenTracker, error) {
+func NewTokenTracker(logger *log.Logger, config *Config, s Store) (*TokenTracker, error) {
 	t := &TokenTracker{
-		logger: logger,
-		config: config,
-		store:  store,
+		logger:       logger,
+		config:       config,
+		store:        s,
+		status:       &syncStatus{},
+		broker:       NewBroker(),
+		retryDelay:   syncBackoff,
+		headInterval: headCheckInterval,
 	}
 
-	client, err := jsonrpc.NewClient(config.Endpoint)
+	endpoints := []*EndpointConfig{}
+	for url, endpoint := range config.Endpoints {
+		endpoint := endpoint
+		endpoint.URL = url
+		endpoints = append(endpoints, &endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].URL < endpoints[j].URL
+	})
+	if len(endpoints) == 0 {
+		endpoints = append(endpoints, &EndpointConfig{URL: config.Endpoint})
+	}
+	client, err := newFailoverClient(logger, endpoints)
 	if err != nil {
 		return nil, err
 	}
 	t.client = client
+	t.resolver = NewResolver(logger, client, s)
+	t.blocks = newBlockCache(newEth(client))
+	t.notifier = NewNotifier(logger, s, func() uint64 {
+		return t.status.status().LastBlock
+	}, config.AllowPrivateWebhooks)
 
 	boltdbStore, err := trackerboltdb.New(config.BoltDBPath)
 	if err != nil {
@@ -71,87 +155,308 @@ func NewTokenTracker(logger *log.Logger, config *Config, store Store) (*TokenTra
 
 	trackerConfig := tracker.DefaultConfig()
 	trackerConfig.BatchSize = uint64(config.BatchSize)
-	t.tracker = tracker.NewTracker(client.Eth(), trackerConfig)
-	t.tracker.SetStore(boltdbStore)
-
-	// token Transfer event
-	t.tracker.SetFilterTopics([]*web3.Hash{
-		&transferEventTopic,
-	})
+	// erc20 and erc721 Transfer and erc1155 TransferSingle and
+	// TransferBatch events
+	t.provider = newProvider(client, store.Topics(), newBatchSizer(logger, uint64(config.BatchSize)))
+	t.trackerConfig = trackerConfig
+	t.trackerStore = boltdbStore
 
 	return t, nil
 }
 
-// Sync starts the tracker
+// Sync runs the tracker until the context is done or it fails. The
+// transient errors of the json-rpc endpoints and the store are retried with
+// an exponential backoff, any other error stops the tracker and it is
+// returned.
 func (t *TokenTracker) Sync(ctx context.Context) error {
-	if t.config.ProgressBar {
-		if err := t.startProgressBar(ctx); err != nil {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t.lock.Lock()
+	if t.stopped {
+		t.lock.Unlock()
+		return nil
+	}
+	t.closeCh = cancel
+	t.doneCh = make(chan struct{})
+	t.lock.Unlock()
+
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		close(t.doneCh)
+	}()
+
+	err := t.retry(ctx, "query the head of the chain", func() error {
+		return t.startProgress(ctx, &wg)
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	for _, run := range []func(context.Context){t.client.Run, t.resolver.Run, t.notifier.Run, t.trackHead} {
+		wg.Add(1)
+		go func(run func(context.Context)) {
+			defer wg.Done()
+			run(ctx)
+		}(run)
+	}
+
+	if err := t.retry(ctx, "backfill", func() error { return t.backfill(ctx) }); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	// the go-web3 tracker resumes from its last synced block and it is
+	// restarted from the first block of an event that fails
+	err = t.retry(ctx, "sync", func() error { return t.track(ctx) })
+	if err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// track runs a go-web3 tracker until the context is done, the tracker fails
+// or an event fails to be handled. The events are not retried in place, the
+// go-web3 tracker does not wait for them and it drops the next events once
+// the channel is full. Instead, the tracker is stopped and its last block is
+// rewound to the block before the event that failed, the blocks after it are
+// synced again once the tracker restarts.
+func (t *TokenTracker) track(ctx context.Context) error {
+	if t.rewinding {
+		if err := t.rewind(t.rewindTo); err != nil {
 			return err
 		}
+		t.rewinding = false
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	eventCh := make(chan *tracker.Event, 1024)
-	t.tracker.EventCh = eventCh
+	s := &closableStore{Store: t.trackerStore}
+	tr := t.newTracker(eventCh)
+	tr.SetStore(s)
 
-	ctx, cancel := context.WithCancel(ctx)
-	t.closeCh = cancel
+	syncCh := make(chan error, 1)
+	go func() {
+		err := tr.Sync(ctx)
+		if err == nil {
+			t.status.setSynced()
+			tr.Polling(ctx)
+		}
+		syncCh <- err
+	}()
 
-	var syncErr error
-	handleErr := func(err error) {
+	// stop stops the go-web3 tracker. Its polling is not waited for, the
+	// writes to its store are refused instead.
+	stop := func() {
 		cancel()
-		syncErr = err
+		if syncCh != nil {
+			<-syncCh
+		}
+		s.close()
+	}
+	// handle handles an event and schedules the rewind if it fails
+	handle := func(evnt *tracker.Event) error {
+		err := t.handleEvent(evnt)
+		if err == nil {
+			return nil
+		}
+		stop()
+		if first, ok := firstBlock(evnt); ok && (!t.rewinding || first < t.rewindTo) {
+			t.rewinding, t.rewindTo = true, first
+		}
+		if t.rewinding && t.rewind(t.rewindTo) == nil {
+			t.rewinding = false
+		}
+		return err
 	}
 
-	go func() {
-		for {
-			select {
-			case evnt := <-eventCh:
-				for _, r := range evnt.RemovedLogs {
-					if err := t.store.RemoveReceipts(r.BlockHash); err != nil {
-						handleErr(err)
-						return
-					}
-				}
-				if len(evnt.AddedLogs) != 0 {
-					if err := t.store.WriteReceipt(evnt.AddedLogs); err != nil {
-						handleErr(err)
-						return
+	for {
+		select {
+		case evnt := <-eventCh:
+			if err := handle(evnt); err != nil {
+				return err
+			}
+
+		case err := <-syncCh:
+			syncCh = nil
+			if err == nil {
+				continue
+			}
+			// the tracker stored its last block after sending the events
+			// queued, they are handled before it restarts
+			s.close()
+			for {
+				select {
+				case evnt := <-eventCh:
+					if err := handle(evnt); err != nil {
+						return err
 					}
+				default:
+					return err
 				}
-			case <-ctx.Done():
-				return
 			}
+
+		case <-ctx.Done():
+			stop()
+			return ctx.Err()
 		}
-	}()
+	}
+}
+
+// newTracker creates a go-web3 tracker that sends its events to eventCh
+func (t *TokenTracker) newTracker(eventCh chan *tracker.Event) *tracker.Tracker {
+	tr := tracker.NewTracker(t.provider, t.trackerConfig)
+	tr.SetStore(t.trackerStore)
+	tr.EventCh = eventCh
+	tr.SyncCh = t.syncCh
+	return tr
+}
+
+// handleEvent writes the blocks and the logs added by an event and
+// removes the ones of the blocks reorged
+func (t *TokenTracker) handleEvent(evnt *tracker.Event) error {
+	for _, b := range evnt.Added {
+		t.blocks.add(b)
+	}
+	if len(evnt.Removed) != 0 || len(evnt.RemovedLogs) != 0 {
+		metricReorgs.Inc()
+	}
+	// the go-web3 tracker does not sync again the logs removed, the ones
+	// of an event that failed are removed with the next event
+	removedLogs := append(t.removed, evnt.RemovedLogs...)
+	t.removed = nil
+
+	removed := map[web3.Hash]struct{}{}
+	for i, r := range removedLogs {
+		if err := t.removeLog(r, removed); err != nil {
+			t.removed = removedLogs[i:]
+			return err
+		}
+	}
+	if len(evnt.AddedLogs) != 0 {
+		// timestamp the transfers with the time of their blocks
+		blocks, err := t.blocks.logBlocks(evnt.AddedLogs)
+		if err != nil {
+			return err
+		}
+		if err := t.writeLogs(evnt.AddedLogs, blocks); err != nil {
+			return err
+		}
+	}
+	for _, b := range evnt.Added {
+		t.status.indexed(b.Number)
+	}
+	if len(evnt.Added) != 0 || len(evnt.AddedLogs) != 0 {
+		if err := t.confirm(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeLog removes the receipt of a log of a block reorged and notifies
+// the removal of the block once
+func (t *TokenTracker) removeLog(r *web3.Log, removed map[web3.Hash]struct{}) error {
+	if r.BlockNumber <= t.confirmed {
+		t.logger.Printf("[WARN] Reorg removed the confirmed transfers of block %d", r.BlockNumber)
+	}
+	if err := t.store.RemoveReceipts(r.BlockHash); err != nil {
+		return err
+	}
+	t.blocks.remove(r.BlockHash)
+	metricLogsRemoved.Inc()
+
+	if _, ok := removed[r.BlockHash]; ok {
+		return nil
+	}
+	t.broker.Publish(&Event{Type: EventRemoved, BlockHash: r.BlockHash.String(), BlockNumber: r.BlockNumber})
+	if err := t.notifier.Removed(r.BlockHash.String()); err != nil {
+		return err
+	}
+	removed[r.BlockHash] = struct{}{}
+	return nil
+}
+
+// writeLogs writes the logs and the blocks that timestamp them and notifies
+// the new transfers. The logs that were already stored are not published
+// again, so a replay of the logs does not duplicate the events. The
+// deliveries of the webhooks are queued for all the logs instead, the
+// store skips the ones already queued and a replay queues the deliveries
+// of the logs written by an attempt that failed to queue them.
+func (t *TokenTracker) writeLogs(logs []*web3.Log, blocks []*store.Block) error {
+	if err := t.store.WriteBlocks(blocks); err != nil {
+		return err
+	}
+	written, err := t.store.WriteReceipt(logs)
+	if err != nil {
+		return err
+	}
+	metricLogsWritten.Add(float64(len(written)))
+
+	for _, log := range logs {
+		t.resolver.Enqueue(log.Address)
+	}
+	if len(written) != 0 {
+		t.broker.Publish(newAddedEvent(written, blocks))
+	}
+	return t.notifier.Added(newAddedEvent(logs, blocks))
+}
 
-	if err := t.tracker.Sync(ctx); err != nil {
-		handleErr(err)
+// confirm marks as confirmed the transfers that are Confirmations blocks
+// below the last indexed block
+func (t *TokenTracker) confirm() error {
+	lastBlock := t.status.status().LastBlock
+	if lastBlock < t.config.Confirmations {
+		return nil
 	}
-	if ctx.Err() == nil {
-		t.tracker.Polling(ctx)
+	num := lastBlock - t.config.Confirmations
+	if err := t.store.ConfirmTransfers(num); err != nil {
+		return err
 	}
-	return syncErr
+	t.confirmed = num
+	return nil
 }
 
-func (t *TokenTracker) startProgressBar(ctx context.Context) error {
-	lastKnownBlock, err := t.client.Eth().BlockNumber()
+// startProgress tracks the progress of the historical sync in the status
+// and in the progress bar if enabled
+func (t *TokenTracker) startProgress(ctx context.Context, wg *sync.WaitGroup) error {
+	lastKnownBlock, err := t.provider.BlockNumber()
 	if err != nil {
 		return err
 	}
+	t.status.setHead(lastKnownBlock)
 
 	syncCh := make(chan uint64, 100)
-	t.tracker.SyncCh = syncCh
+	t.syncCh = syncCh
 
-	bar := pb.New(int(lastKnownBlock))
-	bar.Start()
+	var bar *pb.ProgressBar
+	if t.config.ProgressBar {
+		bar = pb.New(int(lastKnownBlock))
+		bar.Start()
+	}
 
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case n := <-syncCh:
-				bar.SetCurrent(int64(n))
+				t.status.indexed(n)
+				if bar != nil {
+					bar.SetCurrent(int64(n))
+				}
 			}
 		}
 	}()
@@ -159,8 +464,49 @@ func (t *TokenTracker) startProgressBar(ctx context.Context) error {
 	return nil
 }
 
-// Stop stops the tracker
+// trackHead queries the head of the chain until the context is done. The
+// indexed blocks only move the head up to the last indexed block, the lag
+// grows with the head if the sync stalls.
+func (t *TokenTracker) trackHead(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(t.headInterval):
+		case <-ctx.Done():
+			return
+		}
+
+		num, err := t.provider.BlockNumber()
+		if err != nil {
+			t.logger.Printf("[WARN] Failed to query the head of the chain: %v", err)
+			continue
+		}
+		t.status.setHead(num)
+	}
+}
+
+// Status returns the sync status of the tracker
+func (t *TokenTracker) Status() *Status {
+	status := t.status.status()
+	status.BatchSize = t.provider.batch.get()
+	return status
+}
+
+// Subscribe subscribes to the transfers written and removed by the tracker
+func (t *TokenTracker) Subscribe(filter Filter) *Subscription {
+	return t.broker.Subscribe(filter)
+}
+
+// Stop stops the tracker and waits for the sync to return before it
+// closes the store
 func (t *TokenTracker) Stop() {
-	t.closeCh()
+	t.lock.Lock()
+	t.stopped = true
+	closeCh, doneCh := t.closeCh, t.doneCh
+	t.lock.Unlock()
+
+	if closeCh != nil {
+		closeCh()
+		<-doneCh
+	}
 	t.store.Close()
 }
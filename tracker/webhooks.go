@@ -0,0 +1,484 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/umbracle/go-web3"
+)
+
+const (
+	// webhookPollInterval is the interval to check for pending deliveries
+	webhookPollInterval = time.Second
+
+	// webhookBatch is the number of pending deliveries sent at once
+	webhookBatch = 100
+
+	// webhookMaxAttempts is the number of attempts before a delivery fails
+	webhookMaxAttempts = 10
+
+	// webhookBaseBackoff and webhookMaxBackoff bound the time between the
+	// attempts of a delivery, that doubles with each attempt
+	webhookBaseBackoff = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+
+	// webhookTimeout is the time for the receiver to reply
+	webhookTimeout = 10 * time.Second
+
+	// webhookWorkers is the number of webhooks that are sent to at once
+	webhookWorkers = 8
+)
+
+// privateNetworks are the ranges of addresses that are not public besides
+// the loopback and the link-local ones
+var privateNetworks = parseNetworks(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	res := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, network)
+	}
+	return res
+}
+
+// IsPrivateIP returns true if the address is not a public address
+func IsPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPayload is the body posted to a webhook. The transfer payloads
+// include the transfers of a block that match the webhook and the reversal
+// payloads the same transfers once the block is removed by a reorg.
+type WebhookPayload struct {
+	Type    string
+	Webhook string
+
+	BlockHash   string
+	BlockNumber uint64
+
+	Transfers      []*store.Transfer      `json:",omitempty"`
+	NFTTransfers   []*store.NFTTransfer   `json:",omitempty"`
+	MultiTransfers []*store.MultiTransfer `json:",omitempty"`
+}
+
+// Sign returns the signature of a payload with the secret of a webhook. The
+// signature is sent in the X-Signature header as sha256=<hex>.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// splitBlocks splits the transfers of an added event by block
+func splitBlocks(evnt *Event) []*Event {
+	res := []*Event{}
+	blocks := map[string]*Event{}
+	block := func(p store.LogPosition) *Event {
+		b, ok := blocks[p.BlockHash]
+		if !ok {
+			b = &Event{Type: EventAdded, BlockHash: p.BlockHash, BlockNumber: p.BlockNumber}
+			blocks[p.BlockHash] = b
+			res = append(res, b)
+		}
+		return b
+	}
+	for _, t := range evnt.Transfers {
+		b := block(t.LogPosition)
+		b.Transfers = append(b.Transfers, t)
+	}
+	for _, t := range evnt.NFTTransfers {
+		b := block(t.LogPosition)
+		b.NFTTransfers = append(b.NFTTransfers, t)
+	}
+	for _, t := range evnt.MultiTransfers {
+		b := block(t.LogPosition)
+		b.MultiTransfers = append(b.MultiTransfers, t)
+	}
+	return res
+}
+
+// webhookFilter returns the filter of the transfers of a webhook
+func webhookFilter(w *store.Webhook) *filterSet {
+	toAddresses := func(addrs []string) []web3.Address {
+		res := []web3.Address{}
+		for _, addr := range addrs {
+			res = append(res, web3.HexToAddress(addr))
+		}
+		return res
+	}
+	return newFilterSet(Filter{
+		Tokens:   toAddresses(w.Tokens),
+		Accounts: toAddresses(w.Accounts),
+	})
+}
+
+// Notifier posts the transfers that match the webhooks. The deliveries are
+// queued in the store and sent once their block has enough confirmations.
+// The failed deliveries are retried with an exponential backoff.
+type Notifier struct {
+	logger *log.Logger
+	store  store.WebhookStore
+	client *http.Client
+
+	// head returns the last indexed block
+	head func() uint64
+
+	// now returns the current time
+	now func() time.Time
+
+	notifyCh chan struct{}
+}
+
+// newWebhookClient creates the client that posts the webhooks. The
+// redirects are not followed. Unless private webhooks are allowed, the
+// connections to private addresses are refused once the host is resolved,
+// so a webhook cannot reach them by resolving to another address after it
+// is created.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+	}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || IsPrivateIP(ip) {
+				return fmt.Errorf("the webhook address %s is private", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			// the webhooks are not sent through a proxy, the address
+			// dialed is the one of the webhook
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// the redirect is a failed attempt
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// NewNotifier creates a new webhook notifier. The webhooks are only sent
+// to public addresses unless allowPrivate is set.
+func NewNotifier(logger *log.Logger, store store.WebhookStore, head func() uint64, allowPrivate bool) *Notifier {
+	return &Notifier{
+		logger:   logger,
+		store:    store,
+		client:   newWebhookClient(allowPrivate),
+		head:     head,
+		now:      time.Now,
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+func (n *Notifier) notify() {
+	select {
+	case n.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Added queues a delivery for each block of the event with transfers that
+// match a webhook
+func (n *Notifier) Added(evnt *Event) error {
+	webhooks, err := n.store.ListWebhooks()
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	deliveries := []*store.Delivery{}
+	for _, block := range splitBlocks(evnt) {
+		for _, w := range webhooks {
+			match := webhookFilter(w).apply(block)
+			if match == nil {
+				continue
+			}
+			payload, err := json.Marshal(&WebhookPayload{
+				Type:           store.DeliveryTransfer,
+				Webhook:        w.ID,
+				BlockHash:      block.BlockHash,
+				BlockNumber:    block.BlockNumber,
+				Transfers:      match.Transfers,
+				NFTTransfers:   match.NFTTransfers,
+				MultiTransfers: match.MultiTransfers,
+			})
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, &store.Delivery{
+				WebhookID:   w.ID,
+				Type:        store.DeliveryTransfer,
+				BlockHash:   block.BlockHash,
+				BlockNumber: block.BlockNumber,
+				ReadyBlock:  block.BlockNumber + w.Confirmations,
+				Payload:     string(payload),
+				Status:      store.DeliveryPending,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := n.store.WriteDeliveries(deliveries); err != nil {
+		return err
+	}
+	n.notify()
+	return nil
+}
+
+// Removed drops the deliveries of a block removed by a reorg that were not
+// sent yet and queues a reversal for the ones already sent
+func (n *Notifier) Removed(blockHash string) error {
+	delivered, err := n.store.RevertDeliveries(blockHash)
+	if err != nil {
+		return err
+	}
+	return n.reverse(delivered)
+}
+
+// reverse queues the reversal of delivered transfer deliveries. The
+// reversals are sent right away.
+func (n *Notifier) reverse(delivered []*store.Delivery) error {
+	if len(delivered) == 0 {
+		return nil
+	}
+	reversals := []*store.Delivery{}
+	for _, d := range delivered {
+		var payload WebhookPayload
+		if err := json.Unmarshal([]byte(d.Payload), &payload); err != nil {
+			return err
+		}
+		payload.Type = store.DeliveryReversal
+
+		data, err := json.Marshal(&payload)
+		if err != nil {
+			return err
+		}
+		reversals = append(reversals, &store.Delivery{
+			WebhookID:   d.WebhookID,
+			Type:        store.DeliveryReversal,
+			BlockHash:   d.BlockHash,
+			BlockNumber: d.BlockNumber,
+			Payload:     string(data),
+			Status:      store.DeliveryPending,
+		})
+	}
+	if err := n.store.WriteDeliveries(reversals); err != nil {
+		return err
+	}
+	n.notify()
+	return nil
+}
+
+// Run sends the pending deliveries until the context is done
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := n.deliverPending(ctx); err != nil {
+			n.logger.Printf("[ERR] Failed to send the webhook deliveries: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-n.notifyCh:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverPending sends the deliveries that are ready. The deliveries of a
+// webhook are sent in order and the webhooks are sent to concurrently, so
+// that a slow receiver does not hold the deliveries of the others.
+func (n *Notifier) deliverPending(ctx context.Context) error {
+	deliveries, err := n.store.PendingDeliveries(n.head(), uint64(n.now().Unix()), webhookBatch)
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	byWebhook := map[string][]*store.Delivery{}
+	for _, d := range deliveries {
+		if _, ok := byWebhook[d.WebhookID]; !ok {
+			ids = append(ids, d.WebhookID)
+		}
+		byWebhook[d.WebhookID] = append(byWebhook[d.WebhookID], d)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		lastErr error
+	)
+	workers := make(chan struct{}, webhookWorkers)
+	for _, id := range ids {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			if err := n.deliverWebhook(ctx, id, byWebhook[id]); err != nil {
+				lock.Lock()
+				lastErr = err
+				lock.Unlock()
+			}
+		}(id)
+	}
+	wg.Wait()
+	return lastErr
+}
+
+// deliverWebhook sends the deliveries of a webhook in order. The remaining
+// deliveries are left for the next round once an attempt fails, so that a
+// receiver that is down only costs one timeout per round.
+func (n *Notifier) deliverWebhook(ctx context.Context, id string, deliveries []*store.Delivery) error {
+	w, err := n.store.GetWebhook(id)
+	if err != nil {
+		if err == store.ErrNotFound {
+			// the webhook was deleted with its deliveries
+			return nil
+		}
+		return err
+	}
+	for _, d := range deliveries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := n.deliver(ctx, w, d); err != nil {
+			return err
+		}
+		if d.Status != store.DeliveryDelivered {
+			return nil
+		}
+	}
+	return nil
+}
+
+// deliver sends a delivery and updates its state
+func (n *Notifier) deliver(ctx context.Context, w *store.Webhook, d *store.Delivery) error {
+	d.Attempts++
+	if err := n.post(ctx, w, d); err != nil {
+		if ctx.Err() != nil {
+			// the notifier is stopping, the delivery is sent on restart
+			return nil
+		}
+		d.LastError = err.Error()
+		if d.Attempts >= webhookMaxAttempts {
+			d.Status = store.DeliveryFailed
+		} else {
+			d.NextAttempt = uint64(n.now().Add(backoff(d.Attempts)).Unix())
+		}
+	} else {
+		d.Status = store.DeliveryDelivered
+		d.LastError = ""
+	}
+
+	err := n.store.UpdateDelivery(d)
+	if err != store.ErrNotFound {
+		return err
+	}
+	// the delivery was removed while it was sent. Either the webhook was
+	// deleted or the block was reverted and the reversal has to be sent.
+	if d.Status != store.DeliveryDelivered || d.Type != store.DeliveryTransfer {
+		return nil
+	}
+	if _, err := n.store.GetWebhook(w.ID); err != nil {
+		if err == store.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return n.reverse([]*store.Delivery{d})
+}
+
+// post posts the payload of a delivery to the webhook. Any status other
+// than 2xx is a failed attempt.
+func (n *Notifier) post(ctx context.Context, w *store.Webhook, d *store.Delivery) error {
+	payload := []byte(d.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", w.ID)
+	req.Header.Set("X-Delivery-ID", fmt.Sprint(d.ID))
+	req.Header.Set("X-Signature", Sign(w.Secret, payload))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// drain the body to reuse the connection
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff returns the time before the next attempt of a delivery
+func backoff(attempts uint64) time.Duration {
+	delay := webhookBaseBackoff
+	for i := uint64(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,325 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ferranbt/go-eth-token-tracker/store"
+	"github.com/ferranbt/go-eth-token-tracker/store/memory"
+	"github.com/umbracle/go-web3"
+	"github.com/umbracle/go-web3/tracker"
+)
+
+// webhookReceiver records the payloads posted to a webhook. It fails the
+// requests while fail is set.
+type webhookReceiver struct {
+	t        *testing.T
+	secret   string
+	fail     bool
+	payloads []*WebhookPayload
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if r.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if req.Header.Get("X-Signature") != Sign(r.secret, data) {
+		r.t.Fatal("bad signature")
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		r.t.Fatal(err)
+	}
+	r.payloads = append(r.payloads, &payload)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	receiver := &webhookReceiver{t: t, secret: "secret"}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	token1 := web3.Address{0x1}
+	token2 := web3.Address{0x2}
+
+	s := memory.New()
+	if err := s.CreateWebhook(&store.Webhook{
+		ID:            "a",
+		URL:           srv.URL,
+		Secret:        "secret",
+		Tokens:        store.AddressList{token1.String()},
+		Confirmations: 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	head := uint64(0)
+	now := time.Unix(1000, 0)
+
+	n := NewNotifier(log.New(ioutil.Discard, "", 0), s, func() uint64 { return head }, true)
+	n.now = func() time.Time { return now }
+
+	transfer := func(token web3.Address, hash string, num uint64) *store.Transfer {
+		return &store.Transfer{
+			LogPosition: store.LogPosition{BlockHash: hash, BlockNumber: num},
+			Addr:        token.String(),
+		}
+	}
+	if err := n.Added(&Event{
+		Type: EventAdded,
+		Transfers: []*store.Transfer{
+			transfer(token1, "0x1", 1),
+			transfer(token2, "0x1", 1),
+			transfer(token2, "0x2", 2),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func() {
+		if err := n.deliverPending(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the transfers are sent with enough confirmations
+	head = 1
+	deliver()
+	if len(receiver.payloads) != 0 {
+		t.Fatal("the delivery is not confirmed")
+	}
+
+	// a failed delivery is retried after the backoff
+	head = 2
+	receiver.fail = true
+	deliver()
+	deliveries, err := s.ListDeliveries("a", store.QueryPagination{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Attempts != 1 || deliveries[0].NextAttempt != 1010 || deliveries[0].LastError == "" {
+		t.Fatal("bad failed delivery")
+	}
+
+	receiver.fail = false
+	deliver()
+	if len(receiver.payloads) != 0 {
+		t.Fatal("the delivery is retried before the backoff")
+	}
+	now = now.Add(webhookBaseBackoff)
+	deliver()
+	if len(receiver.payloads) != 1 {
+		t.Fatal("the delivery is not retried")
+	}
+	payload := receiver.payloads[0]
+	if payload.Type != store.DeliveryTransfer || payload.BlockHash != "0x1" || len(payload.Transfers) != 1 || payload.Transfers[0].Addr != token1.String() {
+		t.Fatal("bad payload")
+	}
+
+	// the reorg reverts the delivered transfers
+	if err := n.Removed("0x1"); err != nil {
+		t.Fatal(err)
+	}
+	deliver()
+	if len(receiver.payloads) != 2 {
+		t.Fatal("the reversal is not sent")
+	}
+	payload = receiver.payloads[1]
+	if payload.Type != store.DeliveryReversal || payload.BlockHash != "0x1" || len(payload.Transfers) != 1 {
+		t.Fatal("bad reversal payload")
+	}
+
+	if deliveries, err = s.ListDeliveries("a", store.QueryPagination{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Status != store.DeliveryDelivered || deliveries[1].Status != store.DeliveryDelivered {
+		t.Fatal("bad deliveries")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	cases := []struct {
+		attempts uint64
+		delay    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{5, 160 * time.Second},
+		{9, 2560 * time.Second},
+		{12, time.Hour},
+	}
+	for _, c := range cases {
+		if delay := backoff(c.attempts); delay != c.delay {
+			t.Fatalf("expected %s for %d attempts but found %s", c.delay, c.attempts, delay)
+		}
+	}
+}
+
+func TestWriteLogsReplay(t *testing.T) {
+	s := memory.New()
+	if err := s.CreateWebhook(&store.Webhook{ID: "a", URL: "http://localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	tt := newBackfillTracker(t, "http://localhost", s, tracker.NewInmemStore())
+
+	sub := tt.Subscribe(Filter{})
+	defer sub.Close()
+
+	b := chainBlock(1)
+	logs := []*web3.Log{chainLog(1)}
+	blocks := []*store.Block{{Hash: b.Hash.String(), Number: b.Number, Timestamp: b.Timestamp}}
+
+	// the same logs are written twice but only notified once
+	for i := 0; i < 2; i++ {
+		if err := tt.writeLogs(logs, blocks); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := s.ListDeliveries("a", store.QueryPagination{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery but found %d", len(deliveries))
+	}
+	if evnt := <-sub.EventCh(); len(evnt.Transfers) != 1 {
+		t.Fatal("bad event")
+	}
+	select {
+	case <-sub.EventCh():
+		t.Fatal("the replay is published")
+	default:
+	}
+}
+
+// failingDeliveries fails the writes of the deliveries while fail is set
+type failingDeliveries struct {
+	*memory.Store
+	fail bool
+}
+
+func (f *failingDeliveries) WriteDeliveries(deliveries []*store.Delivery) error {
+	if f.fail {
+		return errors.New("bad deliveries")
+	}
+	return f.Store.WriteDeliveries(deliveries)
+}
+
+func TestWriteLogsNotifyFails(t *testing.T) {
+	s := &failingDeliveries{Store: memory.New(), fail: true}
+	if err := s.CreateWebhook(&store.Webhook{ID: "a", URL: "http://localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	tt := newBackfillTracker(t, "http://localhost", s, tracker.NewInmemStore())
+
+	b := chainBlock(1)
+	logs := []*web3.Log{chainLog(1)}
+	blocks := []*store.Block{{Hash: b.Hash.String(), Number: b.Number, Timestamp: b.Timestamp}}
+
+	// the logs are written but their deliveries are not queued
+	if err := tt.writeLogs(logs, blocks); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// the replay queues the deliveries of the logs already written
+	s.fail = false
+	if err := tt.writeLogs(logs, blocks); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := s.ListDeliveries("a", store.QueryPagination{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery but found %d", len(deliveries))
+	}
+}
+
+func TestWebhookSlowReceiver(t *testing.T) {
+	// the slow receiver replies once the fast one has received its
+	// delivery or after a timeout if the webhooks are sent in sequence
+	fastCh := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-fastCh:
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fastCh)
+	}))
+	defer fast.Close()
+
+	s := memory.New()
+	for _, w := range []*store.Webhook{{ID: "a", URL: slow.URL}, {ID: "b", URL: fast.URL}} {
+		if err := s.CreateWebhook(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := NewNotifier(log.New(ioutil.Discard, "", 0), s, func() uint64 { return 10 }, true)
+	if err := n.Added(&Event{
+		Type: EventAdded,
+		Transfers: []*store.Transfer{{
+			LogPosition: store.LogPosition{BlockHash: "0x1", BlockNumber: 1},
+			Addr:        web3.Address{0x1}.String(),
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.deliverPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		deliveries, err := s.ListDeliveries(id, store.QueryPagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 || deliveries[0].Status != store.DeliveryDelivered {
+			t.Fatalf("the delivery of %s is not sent", id)
+		}
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	received := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+	}))
+	defer target.Close()
+
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	post := func(allowPrivate bool, url string) error {
+		n := NewNotifier(log.New(ioutil.Discard, "", 0), memory.New(), func() uint64 { return 0 }, allowPrivate)
+		return n.post(context.Background(), &store.Webhook{ID: "a", URL: url}, &store.Delivery{Payload: "{}"})
+	}
+
+	// the private addresses are refused when they are dialed
+	if err := post(false, target.URL); err == nil {
+		t.Fatal("the private address is dialed")
+	}
+	if err := post(true, target.URL); err != nil || received != 1 {
+		t.Fatal("the private address is not dialed")
+	}
+
+	// the redirects are not followed
+	if err := post(true, redirect.URL); err == nil || received != 1 {
+		t.Fatal("the redirect is followed")
+	}
+}